@@ -0,0 +1,245 @@
+package bitset
+
+import (
+	"math/rand"
+	"time"
+)
+
+// AllocPolicy decides where an allocator starts searching for free bits
+type AllocPolicy uint32
+
+const (
+	// FirstFit always hands out the lowest free position
+	FirstFit AllocPolicy = iota
+	// NextFit searches from the position after the last allocation, wrapping around at the end
+	NextFit
+	// RandomFit searches from a random position, wrapping around at the end
+	RandomFit
+	// BestFit picks the smallest free run that is large enough. For single IDs every free bit
+	// is a best fit, so IDAllocator treats it as FirstFit
+	BestFit
+)
+
+// IDAllocator hands out unique IDs backed by the bits of a Bitset, a set bit marks the ID as in
+// use. Finding the free bit and setting it happen under a single lock acquisition of the bitset,
+// so concurrent goroutines never get the same ID. The bitset should not be modified directly
+// while it is used by an allocator.
+type IDAllocator struct {
+	bs     *Bitset
+	policy AllocPolicy
+	growby uint32
+	cursor uint32
+	rnd    *rand.Rand
+}
+
+// NewIDAllocator returns an allocator handing out the zero bits of bs according to policy
+func NewIDAllocator(bs *Bitset, policy AllocPolicy) *IDAllocator {
+	return &IDAllocator{bs: bs, policy: policy,
+		rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// SetGrowth makes Allocate grow the bitset by growby bytes using Resize when there is no free
+// ID left, up to the 2^32 IDs a uint32 can number. Passing 0 disables growth, which is the
+// default
+func (a *IDAllocator) SetGrowth(growby uint32) {
+	a.bs.mutex.Lock()
+	defer a.bs.mutex.Unlock()
+	a.growby = growby
+}
+
+// Allocate finds a free ID, marks it used and returns it. ErrFull is returned if all the IDs
+// are in use and growth is disabled or the bitset holds as many IDs as a uint32 can number, the
+// error of Resize if the bitset could not grow
+func (a *IDAllocator) Allocate() (uint32, error) {
+	bs := a.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	var start uint32 = 0
+	switch a.policy {
+	case NextFit:
+		start = a.cursor
+	case RandomFit:
+		if bs.size != 0 {
+			start = uint32(a.rnd.Int63n(int64(bs.size) << 3))
+		}
+	}
+	pos := bs.nextZeroFrom(start)
+	if pos < 0 && start != 0 {
+		pos = bs.nextZeroFrom(0)
+	}
+	if pos < 0 {
+		newsize, ok := grownSize(bs.size, a.growby)
+		if !ok {
+			return 0, ErrFull
+		}
+		pos = int64(bs.size) << 3
+		if err := bs.resize(newsize); err != nil {
+			return 0, err
+		}
+	}
+	id := uint32(pos)
+	bs.setBit(id)
+	a.cursor = id + 1
+	if uint64(a.cursor) >= uint64(bs.size)<<3 {
+		a.cursor = 0
+	}
+	return id, nil
+}
+
+// grownSize returns the size in bytes of a bitset of size bytes grown by growby bytes, at most
+// maxAddressableBytes. It returns false if growth is disabled or the size is already the
+// largest
+func grownSize(size uint32, growby uint32) (uint32, bool) {
+	if growby == 0 || size >= maxAddressableBytes {
+		return 0, false
+	}
+	newsize := uint64(size) + uint64(growby)
+	if newsize > maxAddressableBytes {
+		newsize = maxAddressableBytes
+	}
+	return uint32(newsize), true
+}
+
+// Free releases an ID so that it can be handed out again. ErrNotAllocated is returned if the ID
+// is not in use and ErrRange if it is beyond the capacity
+func (a *IDAllocator) Free(id uint32) error {
+	prev, err := a.bs.TestAndReset(id)
+	if err != nil {
+		return err
+	}
+	if !prev {
+		return ErrNotAllocated
+	}
+	return nil
+}
+
+// IsAllocated returns true if the ID is in use
+func (a *IDAllocator) IsAllocated(id uint32) (bool, error) {
+	return a.bs.IsSet(id)
+}
+
+// Allocated returns the number of IDs in use
+func (a *IDAllocator) Allocated() uint64 {
+	return a.bs.GetSetbitCount()
+}
+
+// Capacity returns the number of IDs the allocator can hand out without growing
+func (a *IDAllocator) Capacity() uint64 {
+	return uint64(a.bs.GetSize()) << 3
+}
+
+// Utilization returns the fraction of the IDs in use, between 0 and 1
+func (a *IDAllocator) Utilization() float64 {
+	bs := a.bs
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if bs.size == 0 {
+		return 0
+	}
+	return float64(bs.setbitc()) / float64(uint64(bs.size)<<3)
+}
+
+// RangeAllocator hands out runs of consecutive bits of a Bitset, for example contiguous blocks
+// of a storage device. A set bit marks the position as in use. Finding a free run and setting
+// it happen under a single lock acquisition of the bitset. FirstFit and BestFit policies are
+// supported, any other policy behaves as FirstFit
+type RangeAllocator struct {
+	bs     *Bitset
+	policy AllocPolicy
+}
+
+// NewRangeAllocator returns an allocator handing out runs of zero bits of bs according to
+// policy
+func NewRangeAllocator(bs *Bitset, policy AllocPolicy) *RangeAllocator {
+	return &RangeAllocator{bs: bs, policy: policy}
+}
+
+// Allocate finds n consecutive free positions, marks them used and returns the first of them.
+// ErrFull is returned if there is no free run large enough, ErrRange if n is 0
+func (a *RangeAllocator) Allocate(n uint32) (uint32, error) {
+	if n == 0 {
+		return 0, ErrRange
+	}
+	bs := a.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	var pos int64 = -1
+	if a.policy == BestFit {
+		pos = a.bestFit(n)
+	} else {
+		pos = bs.findRun(0, n, false)
+	}
+	if pos < 0 {
+		return 0, ErrFull
+	}
+	start := uint32(pos)
+	bs.setRange(start, start+n-1)
+	return start, nil
+}
+
+// Release frees the n positions starting at start so that they can be handed out again.
+// ErrRange is returned if any of them is beyond the capacity
+func (a *RangeAllocator) Release(start uint32, n uint32) error {
+	if n == 0 {
+		return nil
+	}
+	bs := a.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if uint64(start)+uint64(n)-1 >= uint64(bs.size)<<3 {
+		return ErrRange
+	}
+	bs.clearRange(start, start+n-1)
+	return nil
+}
+
+// LargestFree returns the length of the longest run of free positions
+func (a *RangeAllocator) LargestFree() uint64 {
+	bs := a.bs
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	var largest uint64 = 0
+	a.freeRuns(func(start int64, length uint64) bool {
+		if length > largest {
+			largest = length
+		}
+		return true
+	})
+	return largest
+}
+
+// bestFit returns the start of the smallest free run of at least n bits, -1 if there is none.
+// The caller must hold the lock
+func (a *RangeAllocator) bestFit(n uint32) int64 {
+	var best int64 = -1
+	var bestlen uint64 = 0
+	a.freeRuns(func(start int64, length uint64) bool {
+		if length >= uint64(n) && (best < 0 || length < bestlen) {
+			best, bestlen = start, length
+		}
+		return bestlen != uint64(n)
+	})
+	return best
+}
+
+// freeRuns calls f with the start and length of every run of zero bits in order until f
+// returns false. The caller must hold the lock
+func (a *RangeAllocator) freeRuns(f func(start int64, length uint64) bool) {
+	bs := a.bs
+	nbits := int64(bs.size) << 3
+	var pos int64 = 0
+	for pos < nbits {
+		start := bs.findRun(uint32(pos), 1, false)
+		if start < 0 {
+			return
+		}
+		end := bs.findRun(uint32(start), 1, true)
+		if end < 0 {
+			end = nbits
+		}
+		if !f(start, uint64(end-start)) {
+			return
+		}
+		pos = end
+	}
+}
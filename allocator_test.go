@@ -0,0 +1,162 @@
+package bitset
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestIDAllocator(t *testing.T) {
+	for _, policy := range []AllocPolicy{FirstFit, NextFit, RandomFit} {
+		alloc := NewIDAllocator(NewBitset(4), policy)
+		seen := make(map[uint32]bool)
+		for i := 0; i < 32; i++ {
+			id, err := alloc.Allocate()
+			if err != nil {
+				t.Fatalf("Allocate failed for policy %d: %v", policy, err)
+			}
+			if seen[id] {
+				t.Fatalf("Allocate returned %d twice for policy %d", id, policy)
+			}
+			seen[id] = true
+		}
+		if _, err := alloc.Allocate(); err != ErrFull {
+			t.Fatalf("Allocate failed to detect exhaustion for policy %d", policy)
+		}
+		if alloc.Utilization() != 1 {
+			t.Fatalf("Utilization failed, expected 1, got %f", alloc.Utilization())
+		}
+		if err := alloc.Free(17); err != nil {
+			t.Fatalf("Free failed %v", err)
+		}
+		if err := alloc.Free(17); err != ErrNotAllocated {
+			t.Fatal("Free failed to detect double free")
+		}
+		if id, err := alloc.Allocate(); err != nil || id != 17 {
+			t.Fatalf("Allocate failed, expected 17, got %d, %v", id, err)
+		}
+	}
+
+	alloc := NewIDAllocator(NewBitset(2), FirstFit)
+	alloc.Allocate()
+	alloc.Allocate()
+	alloc.Free(0)
+	if id, _ := alloc.Allocate(); id != 0 {
+		t.Fatalf("FirstFit failed, expected 0, got %d", id)
+	}
+	alloc = NewIDAllocator(NewBitset(2), NextFit)
+	alloc.Allocate()
+	alloc.Allocate()
+	alloc.Free(0)
+	if id, _ := alloc.Allocate(); id != 2 {
+		t.Fatalf("NextFit failed, expected 2, got %d", id)
+	}
+
+	alloc = NewIDAllocator(NewBitset(1), FirstFit)
+	alloc.SetGrowth(1)
+	for i := 0; i < 20; i++ {
+		if id, err := alloc.Allocate(); err != nil || id != uint32(i) {
+			t.Fatalf("Allocate with growth failed, expected %d, got %d, %v", i, id, err)
+		}
+	}
+	if alloc.Capacity() != 24 || alloc.Allocated() != 20 {
+		t.Fatalf("Capacity or Allocated failed, got %d and %d", alloc.Capacity(), alloc.Allocated())
+	}
+
+	// growth stops at the IDs a uint32 can number instead of wrapping the size around
+	tests := []struct {
+		size, growby, expected uint32
+		ok                     bool
+	}{
+		{1, 1, 2, true},
+		{1, 0xffffffff, maxAddressableBytes, true},
+		{maxAddressableBytes - 1, 8, maxAddressableBytes, true},
+		{maxAddressableBytes, 1, 0, false},
+		{10, 0, 0, false},
+	}
+	for _, test := range tests {
+		if size, ok := grownSize(test.size, test.growby); size != test.expected || ok != test.ok {
+			t.Fatalf("grownSize(%d, %d) failed, got %d %v", test.size, test.growby, size, ok)
+		}
+	}
+}
+
+func TestIDAllocatorConcurrent(t *testing.T) {
+	alloc := NewIDAllocator(NewBitset(64), NextFit)
+	var mutex sync.Mutex
+	seen := make(map[uint32]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 64; i++ {
+				id, err := alloc.Allocate()
+				if err != nil {
+					t.Errorf("Allocate failed %v", err)
+					return
+				}
+				mutex.Lock()
+				if seen[id] {
+					t.Errorf("Allocate returned %d twice", id)
+				}
+				seen[id] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if !alloc.bs.IsAllSet() {
+		t.Fatal("Allocate failed to claim all the bits")
+	}
+}
+
+func TestRangeAllocator(t *testing.T) {
+	bs := NewBitset(8)
+	alloc := NewRangeAllocator(bs, FirstFit)
+	first, err := alloc.Allocate(10)
+	if err != nil || first != 0 {
+		t.Fatalf("Allocate failed, expected 0, got %d, %v", first, err)
+	}
+	second, err := alloc.Allocate(20)
+	if err != nil || second != 10 {
+		t.Fatalf("Allocate failed, expected 10, got %d, %v", second, err)
+	}
+	if bs.GetSetbitCount() != 30 {
+		t.Fatalf("Allocate failed, expected 30 set bits, got %d", bs.GetSetbitCount())
+	}
+	if _, err = alloc.Allocate(35); err != ErrFull {
+		t.Fatal("Allocate failed to detect lack of space")
+	}
+	if alloc.LargestFree() != 34 {
+		t.Fatalf("LargestFree failed, expected 34, got %d", alloc.LargestFree())
+	}
+	if err = alloc.Release(0, 10); err != nil {
+		t.Fatalf("Release failed %v", err)
+	}
+	// a run reaching beyond the end, even one wrapping around, frees nothing
+	if alloc.Release(12, 0xffffffff) != ErrRange || alloc.Release(60, 5) != ErrRange {
+		t.Fatal("Release failed to reject a range beyond the end")
+	}
+	if bs.GetSetbitCount() != 20 {
+		t.Fatalf("Release failed, expected 20 set bits, got %d", bs.GetSetbitCount())
+	}
+
+	// free runs are now [0, 10) and [30, 64)
+	if pos, _ := alloc.Allocate(8); pos != 0 {
+		t.Fatalf("FirstFit failed, expected 0, got %d", pos)
+	}
+	alloc.Release(0, 8)
+	alloc = NewRangeAllocator(bs, BestFit)
+	if pos, _ := alloc.Allocate(12); pos != 30 {
+		t.Fatalf("BestFit failed, expected 30, got %d", pos)
+	}
+	alloc.Release(30, 12)
+	bs.SetBit(50)
+	// free runs are now [0, 10), [30, 50) and [51, 64)
+	if pos, _ := alloc.Allocate(11); pos != 51 {
+		t.Fatalf("BestFit failed, expected 51, got %d", pos)
+	}
+	if pos, _ := alloc.Allocate(10); pos != 0 {
+		t.Fatalf("BestFit failed, expected 0, got %d", pos)
+	}
+}
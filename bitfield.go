@@ -0,0 +1,282 @@
+package bitset
+
+import (
+	"encoding/binary"
+)
+
+// GetVal64 packs the bits from start to end index in a uint64 number and returns it, right
+// adjusted like GetVal does. It returns out of range error if end exceeds size of the bitset
+// or end - start > 63
+func (bs *Bitset) GetVal64(start uint32, end uint32) (uint64, error) {
+	if end < start {
+		start, end = end, start
+	}
+	if end-start+1 > 64 {
+		return 0, ErrMaxR
+	}
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if end>>3 >= bs.size {
+		return 0, ErrRange
+	}
+	return bs.getVal64(start, end, bs.order), nil
+}
+
+// SetVal64 assigns the lowest end - start + 1 bits of fromval to the bits from start to end
+// index like SetVal does. It returns out of range error if end exceeds size of the bitset or
+// end - start > 63
+func (bs *Bitset) SetVal64(start uint32, end uint32, fromval uint64) error {
+	if end < start {
+		start, end = end, start
+	}
+	numbits := end - start + 1
+	if numbits > 64 {
+		return ErrMaxR
+	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if end>>3 >= bs.size {
+		return ErrRange
+	}
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], fromval<<(64-numbits))
+	bs.writeBits(start, data[:], numbits, bs.order)
+	return nil
+}
+
+// ReadBits returns the n bits starting at start packed in (n+7)/8 bytes. The bit at start is
+// the most significant bit of the first byte, and the unused low bits of the last byte are
+// zero. ErrRange is returned if the bits exceed the size of the bitset
+func (bs *Bitset) ReadBits(start uint32, n uint32) ([]byte, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if n != 0 && (uint64(start)+uint64(n)-1)>>3 >= uint64(bs.size) {
+		return nil, ErrRange
+	}
+	return bs.readBits(start, n, bs.order), nil
+}
+
+// WriteBits assigns the first n bits of data, most significant bit first, to the n bits
+// starting at start. ErrRange is returned if the bits exceed the size of the bitset or data
+// holds less than n bits
+func (bs *Bitset) WriteBits(start uint32, data []byte, n uint32) error {
+	if uint64(len(data))<<3 < uint64(n) {
+		return ErrRange
+	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if n != 0 && (uint64(start)+uint64(n)-1)>>3 >= uint64(bs.size) {
+		return ErrRange
+	}
+	if n != 0 {
+		bs.writeBits(start, data, n, bs.order)
+	}
+	return nil
+}
+
+// getVal64 does the work of GetVal64 on a validated range of at most 64 bits numbered in
+// order, the caller must hold the lock
+func (bs *Bitset) getVal64(start uint32, end uint32, order BitOrder) uint64 {
+	numbits := end - start + 1
+	var data [8]byte
+	copy(data[:], bs.readBits(start, numbits, order))
+	return binary.BigEndian.Uint64(data[:]) >> (64 - numbits)
+}
+
+// readBits does the work of ReadBits on a validated range with the bits of the bytes of the
+// bitset numbered in order, the caller must hold the lock
+func (bs *Bitset) readBits(start uint32, n uint32, order BitOrder) []byte {
+	ret := make([]byte, (uint64(n)+7)>>3)
+	for k := range ret {
+		ret[k] = bs.byteAt(uint64(start)+uint64(k)<<3, order)
+	}
+	if n&7 != 0 {
+		ret[len(ret)-1] &= 0xff << (8 - n&7)
+	}
+	return ret
+}
+
+// writeBits does the work of WriteBits on a validated non-empty range with the bits of the
+// bytes of the bitset numbered in order, the caller must hold the write lock
+func (bs *Bitset) writeBits(start uint32, data []byte, n uint32, order BitOrder) {
+	end := start + n - 1
+	startbyte := start >> 3
+	endbyte := end >> 3
+	bs.beforeWrite(startbyte, endbyte)
+	for i := startbyte; i <= endbyte; i++ {
+		// the 8 bits of data landing in byte i, starting at a negative offset for the first
+		// byte unless start is aligned
+		src := bitsAt(data, int64(i)<<3-int64(start))
+		mask := rangeMask(i, start, end)
+		bs.buf[i] = orderByte(orderByte(bs.buf[i], order)&^mask|src&mask, order)
+		if i == endbyte {
+			break
+		}
+	}
+	bs.modified(OpSetVal, start, end)
+}
+
+// byteAt returns the 8 bits starting at position, most significant bit first, with the bits
+// of the bytes of the bitset numbered in order and zero beyond its end. The caller must hold
+// the lock
+func (bs *Bitset) byteAt(position uint64, order BitOrder) byte {
+	i := position >> 3
+	var b uint16 = 0
+	if i < uint64(bs.size) {
+		b = uint16(orderByte(bs.buf[i], order)) << 8
+	}
+	if i+1 < uint64(bs.size) {
+		b |= uint16(orderByte(bs.buf[i+1], order))
+	}
+	return byte(b >> (8 - position&7))
+}
+
+// bitsAt returns the 8 bits of data starting at bit offset off, most significant bit first,
+// with zeros for the bits before the start or beyond the end of data
+func bitsAt(data []byte, off int64) byte {
+	var b uint16 = 0
+	i := off >> 3
+	if i >= 0 && i < int64(len(data)) {
+		b = uint16(data[i]) << 8
+	}
+	if i+1 >= 0 && i+1 < int64(len(data)) {
+		b |= uint16(data[i+1])
+	}
+	return byte(b >> (8 - off&7))
+}
+
+// GetSignedVal returns the bits from start to end index as a two's complement signed number,
+// sign extended from the bit at start. It returns out of range error if end exceeds size of
+// the bitset or end - start > 31
+func (bs *Bitset) GetSignedVal(start uint32, end uint32) (int32, error) {
+	v, err := bs.GetVal(start, end)
+	if err != nil {
+		return 0, err
+	}
+	if end < start {
+		start, end = end, start
+	}
+	shift := 31 - (end - start)
+	return int32(v<<shift) >> shift, nil
+}
+
+// SetSignedVal assigns the lowest end - start + 1 bits of the two's complement of fromval to
+// the bits from start to end index. It returns out of range error if end exceeds size of the
+// bitset or end - start > 31
+func (bs *Bitset) SetSignedVal(start uint32, end uint32, fromval int32) error {
+	return bs.SetVal(start, end, uint32(fromval))
+}
+
+// GetField returns the bits from start to end index as an unsigned number whose most
+// significant bit is at start if order is MSBFirst, its least significant bit if LSBFirst. The
+// positions are those of the bitset's own order, as for GetVal. It returns out of range error
+// if end exceeds size of the bitset or end - start > 63
+func (bs *Bitset) GetField(start uint32, end uint32, order BitOrder) (uint64, error) {
+	if end < start {
+		start, end = end, start
+	}
+	if end-start+1 > 64 {
+		return 0, ErrMaxR
+	}
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if end>>3 >= bs.size {
+		return 0, ErrRange
+	}
+	return bs.getField(start, end, order), nil
+}
+
+// SetField assigns the lowest end - start + 1 bits of fromval to the bits from start to end
+// index, the most significant one at start if order is MSBFirst, the least significant one if
+// LSBFirst. The positions are those of the bitset's own order, as for SetVal. It returns out of
+// range error if end exceeds size of the bitset or end - start > 63
+func (bs *Bitset) SetField(start uint32, end uint32, order BitOrder, fromval uint64) error {
+	if end < start {
+		start, end = end, start
+	}
+	if end-start+1 > 64 {
+		return ErrMaxR
+	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if end>>3 >= bs.size {
+		return ErrRange
+	}
+	bs.setField(start, end, order, fromval)
+	return nil
+}
+
+// GetSignedField returns the bits from start to end index as a two's complement signed number
+// sign extended from its most significant bit, with the bits numbered in order. It returns out
+// of range error if end exceeds size of the bitset or end - start > 63
+func (bs *Bitset) GetSignedField(start uint32, end uint32, order BitOrder) (int64, error) {
+	v, err := bs.GetField(start, end, order)
+	if err != nil {
+		return 0, err
+	}
+	if end < start {
+		start, end = end, start
+	}
+	shift := 63 - (end - start)
+	return int64(v<<shift) >> shift, nil
+}
+
+// SetSignedField assigns the lowest end - start + 1 bits of the two's complement of fromval
+// to the bits from start to end index, with the bits numbered in order. It returns out of
+// range error if end exceeds size of the bitset or end - start > 63
+func (bs *Bitset) SetSignedField(start uint32, end uint32, order BitOrder, fromval int64) error {
+	return bs.SetField(start, end, order, uint64(fromval))
+}
+
+// getField does the work of GetField on a validated range, the caller must hold the lock
+func (bs *Bitset) getField(start uint32, end uint32, order BitOrder) uint64 {
+	if order != LSBFirst {
+		return bs.getVal64(start, end, bs.order)
+	}
+	numbits := end - start + 1
+	startbyte := start >> 3
+	endbyte := end >> 3
+	got := 8 - start&7
+	ret := uint64(bs.lsb(bs.buf[startbyte])) >> (start & 7)
+	for i := startbyte + 1; i <= endbyte; i++ {
+		ret |= uint64(bs.lsb(bs.buf[i])) << got
+		got += 8
+	}
+	if numbits < 64 {
+		ret &= 1<<numbits - 1
+	}
+	return ret
+}
+
+// setField does the work of SetField on a validated range, the caller must hold the write lock
+func (bs *Bitset) setField(start uint32, end uint32, order BitOrder, fromval uint64) {
+	numbits := end - start + 1
+	if order != LSBFirst {
+		var data [8]byte
+		binary.BigEndian.PutUint64(data[:], fromval<<(64-numbits))
+		bs.writeBits(start, data[:], numbits, bs.order)
+		return
+	}
+	startbyte := start >> 3
+	endbyte := end >> 3
+	bs.beforeWrite(startbyte, endbyte)
+	for i := startbyte; i <= endbyte; i++ {
+		var mask byte = 0xff
+		var src byte
+		if i == startbyte {
+			mask <<= start & 7
+			src = byte(fromval << (start & 7))
+		} else {
+			// the bit of the field landing on the lowest bit of byte i
+			src = byte(fromval >> ((i-startbyte)<<3 - start&7))
+		}
+		if i == endbyte {
+			mask &= 0xff >> (7 - end&7)
+		}
+		bs.buf[i] = bs.lsb(bs.lsb(bs.buf[i])&^mask | src&mask)
+		if i == endbyte {
+			break
+		}
+	}
+	bs.modified(OpSetVal, start, end)
+}
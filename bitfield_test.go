@@ -0,0 +1,210 @@
+package bitset
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestVal64(t *testing.T) {
+	bs := NewBitset(16)
+	if err := bs.SetVal64(3, 66, 0xfedcba9876543210); err != nil {
+		t.Fatalf("SetVal64 failed %v", err)
+	}
+	if v, err := bs.GetVal64(3, 66); err != nil || v != 0xfedcba9876543210 {
+		t.Fatalf("GetVal64 failed, got %x %v", v, err)
+	}
+	if v, _ := bs.GetVal(3, 6); v != 0xf {
+		t.Fatalf("SetVal64 failed, GetVal got %x", v)
+	}
+	if v, _ := bs.GetVal64(63, 66); v != 0 {
+		t.Fatalf("GetVal64 failed, got %x", v)
+	}
+	if ok, _ := bs.IsSet(2); ok {
+		t.Fatal("SetVal64 failed, modified the bit before start")
+	}
+	if err := bs.SetVal64(100, 110, 0xffff); err != nil {
+		t.Fatalf("SetVal64 failed %v", err)
+	}
+	if v, _ := bs.GetVal64(99, 111); v != 0xffe {
+		t.Fatalf("SetVal64 failed to truncate, got %x", v)
+	}
+	if _, err := bs.GetVal64(0, 64); err != ErrMaxR {
+		t.Fatal("GetVal64 failed to reject 65 bits")
+	}
+	if err := bs.SetVal64(0, 64, 0); err != ErrMaxR {
+		t.Fatal("SetVal64 failed to reject 65 bits")
+	}
+	if _, err := bs.GetVal64(100, 128); err != ErrRange {
+		t.Fatal("GetVal64 failed to reject range")
+	}
+	if err := bs.SetVal64(128, 100, 0); err != ErrRange {
+		t.Fatal("SetVal64 failed to reject range")
+	}
+}
+
+func TestReadWriteBits(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	bs := NewBitset(32)
+	reference := make([]bool, 256)
+	for iter := 0; iter < 500; iter++ {
+		start := uint32(rnd.Intn(256))
+		n := uint32(rnd.Intn(257 - int(start)))
+		data := make([]byte, (n+7)/8+uint32(rnd.Intn(2)))
+		rnd.Read(data)
+		if err := bs.WriteBits(start, data, n); err != nil {
+			t.Fatalf("WriteBits failed %v", err)
+		}
+		for j := uint32(0); j < n; j++ {
+			reference[start+j] = data[j>>3]&ones[7-(j&7)] != 0
+		}
+		for p := uint32(0); p < 256; p++ {
+			if ok, _ := bs.IsSet(p); ok != reference[p] {
+				t.Fatalf("WriteBits(%d, %d) failed at %d", start, n, p)
+			}
+		}
+
+		start = uint32(rnd.Intn(256))
+		n = uint32(rnd.Intn(257 - int(start)))
+		got, err := bs.ReadBits(start, n)
+		if err != nil || uint32(len(got)) != (n+7)/8 {
+			t.Fatalf("ReadBits failed %v", err)
+		}
+		expected := make([]byte, (n+7)/8)
+		for j := uint32(0); j < n; j++ {
+			if reference[start+j] {
+				expected[j>>3] |= ones[7-(j&7)]
+			}
+		}
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("ReadBits(%d, %d) failed, expected %x, got %x", start, n, expected, got)
+		}
+	}
+	if _, err := bs.ReadBits(250, 7); err != ErrRange {
+		t.Fatal("ReadBits failed to reject range")
+	}
+	if err := bs.WriteBits(250, []byte{0xff}, 7); err != ErrRange {
+		t.Fatal("WriteBits failed to reject range")
+	}
+	if err := bs.WriteBits(0, []byte{0xff}, 9); err != ErrRange {
+		t.Fatal("WriteBits failed to reject short data")
+	}
+}
+
+func TestSignedVal(t *testing.T) {
+	bs := NewBitset(8)
+	if err := bs.SetSignedVal(3, 14, -5); err != nil {
+		t.Fatalf("SetSignedVal failed %v", err)
+	}
+	if v, err := bs.GetSignedVal(3, 14); err != nil || v != -5 {
+		t.Fatalf("GetSignedVal failed, got %d %v", v, err)
+	}
+	if v, _ := bs.GetVal(3, 14); v != 0xffb {
+		t.Fatalf("SetSignedVal failed, GetVal got %x", v)
+	}
+	if err := bs.SetSignedVal(20, 51, -2147483648); err != nil {
+		t.Fatalf("SetSignedVal failed %v", err)
+	}
+	if v, _ := bs.GetSignedVal(51, 20); v != -2147483648 {
+		t.Fatalf("GetSignedVal failed, got %d", v)
+	}
+	if v, _ := bs.GetSignedVal(21, 51); v != 0 {
+		t.Fatalf("GetSignedVal failed, got %d", v)
+	}
+	if _, err := bs.GetSignedVal(0, 32); err != ErrMaxR {
+		t.Fatal("GetSignedVal failed to reject 33 bits")
+	}
+	if err := bs.SetSignedField(0, 63, LSBFirst, -1234567890123); err != nil {
+		t.Fatalf("SetSignedField failed %v", err)
+	}
+	if v, _ := bs.GetSignedField(0, 63, LSBFirst); v != -1234567890123 {
+		t.Fatalf("GetSignedField failed, got %d", v)
+	}
+	bs.SetSignedField(5, 9, MSBFirst, 15)
+	if v, _ := bs.GetSignedField(5, 9, MSBFirst); v != 15 {
+		t.Fatalf("GetSignedField failed, got %d", v)
+	}
+	bs.SetSignedField(5, 9, MSBFirst, 16)
+	if v, _ := bs.GetSignedField(5, 9, MSBFirst); v != -16 {
+		t.Fatalf("GetSignedField failed to wrap, got %d", v)
+	}
+}
+
+func TestFieldBitOrder(t *testing.T) {
+	// a DEFLATE block header: BFINAL = 1 then BTYPE = 2, read from the lowest bit up
+	bs := NewBitsetFromArrayWithOrder([]byte{0x05, 0x00}, LSBFirst)
+	if v, _ := bs.GetField(0, 0, LSBFirst); v != 1 {
+		t.Fatalf("GetField failed, got %d", v)
+	}
+	if v, _ := bs.GetField(1, 2, LSBFirst); v != 2 {
+		t.Fatalf("GetField failed, got %d", v)
+	}
+	if v, _ := bs.GetField(0, 2, MSBFirst); v != 5 {
+		t.Fatalf("GetField failed, got %d", v)
+	}
+
+	// the order of the field is independent of the order of the bitset, whose positions the
+	// fields use as every other method
+	bs = NewBitsetWithOrder(2, LSBFirst)
+	bs.SetBit(9)
+	if v, _ := bs.GetField(8, 11, MSBFirst); v != 4 {
+		t.Fatalf("GetField MSBFirst failed on a LSB-first bitset, got %d", v)
+	}
+	if v, _ := bs.GetField(8, 11, LSBFirst); v != 2 {
+		t.Fatalf("GetField LSBFirst failed on a LSB-first bitset, got %d", v)
+	}
+	bs.SetSignedField(8, 11, MSBFirst, -3)
+	if v, _ := bs.GetSignedVal(8, 11); v != -3 {
+		t.Fatalf("SetSignedField MSBFirst failed on a LSB-first bitset, got %d", v)
+	}
+	msb := NewBitset(2)
+	msb.SetBit(9)
+	if v, _ := msb.GetField(8, 11, LSBFirst); v != 2 {
+		t.Fatalf("GetField LSBFirst failed on a MSB-first bitset, got %d", v)
+	}
+	if msb.SetField(4, 11, LSBFirst, 0x81); msb.GetBytes()[0] != 0x08 || msb.GetBytes()[1] != 0x10 {
+		t.Fatalf("SetField LSBFirst failed on a MSB-first bitset, got %x", msb.GetBytes())
+	}
+
+	rnd := rand.New(rand.NewSource(2))
+	for _, order := range []BitOrder{MSBFirst, LSBFirst} {
+		bs = NewBitsetWithOrder(16, order)
+		for iter := 0; iter < 500; iter++ {
+			start := uint32(rnd.Intn(128))
+			n := uint32(rnd.Intn(64)) + 1
+			if start+n > 128 {
+				continue
+			}
+			end := start + n - 1
+			before := bs.Freeze()
+			v := rnd.Uint64()
+			if err := bs.SetField(start, end, LSBFirst, v); err != nil {
+				t.Fatalf("SetField failed %v", err)
+			}
+			for p := uint32(0); p < 128; p++ {
+				bit, _ := bs.IsSet(p)
+				expected, _ := before.IsSet(p)
+				if p >= start && p <= end {
+					expected = v>>(p-start)&1 != 0
+				}
+				if bit != expected {
+					t.Fatalf("SetField(%d, %d) failed at %d", start, end, p)
+				}
+			}
+			if got, _ := bs.GetField(start, end, LSBFirst); n < 64 && got != v&(1<<n-1) ||
+				n == 64 && got != v {
+				t.Fatalf("GetField(%d, %d) failed, got %x", start, end, got)
+			}
+			bs.SetField(start, end, MSBFirst, v)
+			if got, _ := bs.GetVal64(start, end); n < 64 && got != v&(1<<n-1) {
+				t.Fatalf("SetField(%d, %d) MSBFirst failed, got %x", start, end, got)
+			}
+		}
+	}
+	if _, err := bs.GetField(100, 128, LSBFirst); err != ErrRange {
+		t.Fatal("GetField failed to reject range")
+	}
+	if err := bs.SetField(0, 64, LSBFirst, 0); err != ErrMaxR {
+		t.Fatal("SetField failed to reject 65 bits")
+	}
+}
@@ -6,10 +6,32 @@ import (
 	"sync"
 )
 
+// BitOrder tells how the bits of a byte, or of a field, are numbered
+type BitOrder int
+
+const (
+	// MSBFirst numbers the bits of a byte from the most significant one, as Redis does. It is
+	// the default order of a Bitset. The first bit of a field read or written by GetField or
+	// SetField in this order is its most significant bit
+	MSBFirst BitOrder = iota
+	// LSBFirst numbers the bits of a byte from the least significant one, as Java's BitSet,
+	// .NET's BitArray and most C bitmaps do. The first bit of a field read or written by
+	// GetField or SetField in this order is its least significant bit, as in DEFLATE streams
+	LSBFirst
+)
+
 type Bitset struct {
 	size  uint32
 	buf   []byte
 	mutex *sync.RWMutex
+	cond  *sync.Cond
+	order BitOrder
+
+	observers    []observer
+	lastobserver uint64
+	dirty        *dirtyPages
+	snapshots    []*Snapshot
+	mapping      fileMapping
 }
 
 // Get a new instace of Bitset with at least specified size in bytes
@@ -18,6 +40,14 @@ func NewBitset(size uint32) *Bitset {
 	return &Bitset{size: size, buf: buf, mutex: &sync.RWMutex{}}
 }
 
+// NewBitsetWithOrder returns a new instance of Bitset of size bytes whose bits are numbered in
+// order within each byte
+func NewBitsetWithOrder(size uint32, order BitOrder) *Bitset {
+	bs := NewBitset(size)
+	bs.order = order
+	return bs
+}
+
 // Get a new instace of Bitset by copying the passed slice
 func NewBitsetFromArrayCopy(input []byte) *Bitset {
 	size := uint32(len(input))
@@ -40,15 +70,44 @@ func NewBitsetFromArray(input []byte) *Bitset {
 	return &Bitset{size: size, buf: input, mutex: &sync.RWMutex{}}
 }
 
+// NewBitsetFromArrayWithOrder returns a new instance of Bitset by taking reference of the
+// passed slice, whose bits are numbered in order within each byte. It is unsafe for the same
+// reasons as NewBitsetFromArray
+func NewBitsetFromArrayWithOrder(input []byte, order BitOrder) *Bitset {
+	bs := NewBitsetFromArray(input)
+	if bs != nil {
+		bs.order = order
+	}
+	return bs
+}
+
+// Order returns the order in which the bits of the bitset are numbered within each byte
+func (bs *Bitset) Order() BitOrder {
+	return bs.order
+}
+
+// ConvertOrder returns a copy of the bitset whose bits are numbered in order within each byte.
+// Every bit keeps its position, so the bytes of the copy are those of the bitset with their
+// bits reversed if the orders differ
+func (bs *Bitset) ConvertOrder(order BitOrder) *Bitset {
+	ret := bs.Clone()
+	if order != bs.order {
+		for i, b := range ret.buf {
+			ret.buf[i] = reversed[b]
+		}
+		ret.order = order
+	}
+	return ret
+}
+
 // Resize expands or contracts a bitset keeping the content intact for
-// the copied bytes
-func (bs *Bitset) Resize(newsize uint32) {
-	newbf := make([]byte, newsize)
+// the copied bytes. The error of a bitset backed by a file that could not be resized is
+// returned, in which case the bitset kept its old size, or is empty if the file could not be
+// mapped again at all
+func (bs *Bitset) Resize(newsize uint32) error {
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
-	copy(newbf, bs.buf)
-	bs.size = newsize
-	bs.buf = newbf
+	return bs.resize(newsize)
 }
 
 // Clone makes a copy of the current Bitset
@@ -58,20 +117,19 @@ func (bs *Bitset) Clone() *Bitset {
 	buf := make([]byte, bs.size)
 	copy(buf, bs.buf)
 	bs.mutex.Unlock()
-	return &Bitset{size: uint32(len(buf)), buf: buf, mutex: mutex}
+	return &Bitset{size: uint32(len(buf)), buf: buf, mutex: mutex, order: bs.order}
 }
 
 // SetBit sets the bit at some position. It returns false if the position exceeds the size of the
 // bitset, true otherwise
 func (bs *Bitset) SetBit(position uint32) bool {
 	bytepos := position >> 3
-	bitpos := 7 - (position & 7)
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
 	if bytepos >= bs.size {
 		return false
 	}
-	bs.buf[bytepos] |= ones[bitpos]
+	bs.setBit(position)
 	return true
 }
 
@@ -79,13 +137,15 @@ func (bs *Bitset) SetBit(position uint32) bool {
 // bitset, true otherwise
 func (bs *Bitset) ResetBit(position uint32) bool {
 	bytepos := position >> 3
-	bitpos := 7 - (position & 7)
+	bitpos := bs.bitpos(position)
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
 	if bytepos >= bs.size {
 		return false
 	}
+	bs.beforeWrite(bytepos, bytepos)
 	bs.buf[bytepos] &= zeros[bitpos]
+	bs.modified(OpReset, position, position)
 	return true
 }
 
@@ -94,12 +154,10 @@ func (bs *Bitset) ResetBit(position uint32) bool {
 func (bs *Bitset) IsSet(position uint32) (bool, error) {
 	bs.mutex.RLock()
 	defer bs.mutex.RUnlock()
-	bytepos, bitpos, err := bs.getBitBytePosition(position)
-	if err != nil {
-		return false, err
+	if position>>3 >= bs.size {
+		return false, ErrRange
 	}
-	b := bs.buf[bytepos] & ones[bitpos]
-	return b != 0, nil
+	return bs.isSet(position), nil
 }
 
 // GetByte returns byte that contains bit corresponding to the position.
@@ -132,22 +190,32 @@ func (bs *Bitset) SetVal(start uint32, end uint32, fromval uint32) error {
 	if numbit_to_set > 32 {
 		return ErrMaxR
 	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if end>>3 >= bs.size {
+		return ErrRange
+	}
+	bs.setVal(start, end, fromval)
+	return nil
+}
+
+// setVal does the work of SetVal on a validated range of at most 32 bits, the caller must hold
+// the write lock
+func (bs *Bitset) setVal(start uint32, end uint32, fromval uint32) {
+	numbit_to_set := end - start + 1
 	startbyte := start >> 3
 	startbitpos := start & 7
 	endbyte := end >> 3
 	endbitpos := end & 7
-	fromval &= ones32[numbit_to_set-1]
+	// a 32 bit value spread over 5 bytes doesn't fit in a uint32 once aligned on the end byte
+	val := uint64(fromval & ones32[numbit_to_set-1])
 	if endbitpos != 7 {
-		fromval <<= 7 - endbitpos
-	}
-	bs.mutex.Lock()
-	defer bs.mutex.Unlock()
-	if endbyte >= bs.size {
-		return ErrRange
+		val <<= 7 - endbitpos
 	}
+	bs.beforeWrite(startbyte, endbyte)
 	tmp := byte(0)
 	for i := endbyte; i >= startbyte; i-- {
-		cur_byte := byte(0xff & fromval)
+		cur_byte := byte(0xff & val)
 		if i == startbyte && startbitpos != 0 {
 			tmp |= ^byte(ones32[7-startbitpos])
 		}
@@ -155,17 +223,17 @@ func (bs *Bitset) SetVal(start uint32, end uint32, fromval uint32) error {
 			tmp |= byte(ones32[7-endbitpos-1])
 		}
 		if tmp != 0 {
-			bs.buf[i] = (cur_byte & ^tmp) | (bs.buf[i] & tmp)
+			bs.buf[i] = bs.msb((cur_byte & ^tmp) | (bs.msb(bs.buf[i]) & tmp))
 		} else {
-			bs.buf[i] = cur_byte
+			bs.buf[i] = bs.msb(cur_byte)
 		}
 		if i == startbyte {
 			break
 		}
-		fromval >>= 8
+		val >>= 8
 		tmp = 0
 	}
-	return nil
+	bs.modified(OpSetVal, start, end)
 }
 
 // GetVal packs the bits from start to end index in a uint32 number and returns.
@@ -181,7 +249,17 @@ func (bs *Bitset) GetVal(start uint32, end uint32) (uint32, error) {
 	if numbit_to_set > 32 {
 		return 0, ErrMaxR
 	}
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if end>>3 >= bs.size {
+		return 0, ErrRange
+	}
+	return bs.getVal(start, end), nil
+}
 
+// getVal does the work of GetVal on a validated range of at most 32 bits, the caller must hold
+// the lock
+func (bs *Bitset) getVal(start uint32, end uint32) uint32 {
 	var ret uint32 = 0
 	startbyte := start >> 3
 	startbitpos := start & 7
@@ -190,7 +268,7 @@ func (bs *Bitset) GetVal(start uint32, end uint32) (uint32, error) {
 	var i uint32 = 0
 
 	for {
-		ret |= uint32(bs.buf[startbyte+i])
+		ret |= uint32(bs.msb(bs.buf[startbyte+i]))
 		if i == 0 && startbitpos != 0 {
 			ret &= (0xff >> startbitpos)
 		}
@@ -203,20 +281,22 @@ func (bs *Bitset) GetVal(start uint32, end uint32) (uint32, error) {
 	if startbyte+i != endbyte {
 		// the bit range spread over 5 bytes
 		ret <<= (endbitpos + 1)
-		ret |= (uint32(bs.buf[endbyte]) >> (7 - endbitpos))
+		ret |= (uint32(bs.msb(bs.buf[endbyte])) >> (7 - endbitpos))
 	} else if endbitpos != 7 {
 		ret >>= (7 - endbitpos)
 	}
-	return ret, nil
+	return ret
 }
 
 // ClearAll sets all the bits in the bitset to zero
 func (bs *Bitset) ClearAll() {
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
+	bs.beforeWriteAll()
 	for i := uint32(0); i < bs.size; i++ {
 		bs.buf[i] = 0
 	}
+	bs.modifiedAll(OpReset)
 }
 
 // ClearRange clears the bits in positions start <= position <= end. It returns non-nil errors if
@@ -225,15 +305,37 @@ func (bs *Bitset) ClearRange(start uint32, end uint32) error {
 	if start > end {
 		start, end = end, start
 	}
-	startbyte := start >> 3
-	startbitpos := start & 7
 	endbyte := end >> 3
-	endbitpos := end & 7
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
 	if endbyte >= bs.size {
 		return ErrRange
 	}
+	bs.clearRange(start, end)
+	return nil
+}
+
+// isSet returns true if the bit at a validated position is set, the caller must hold the lock
+func (bs *Bitset) isSet(position uint32) bool {
+	return bs.buf[position>>3]&ones[bs.bitpos(position)] != 0
+}
+
+// setBit does the work of SetBit on a validated position, the caller must hold the write lock
+func (bs *Bitset) setBit(position uint32) {
+	bytepos := position >> 3
+	bs.beforeWrite(bytepos, bytepos)
+	bs.buf[bytepos] |= ones[bs.bitpos(position)]
+	bs.modified(OpSet, position, position)
+}
+
+// clearRange does the work of ClearRange on a validated range, the caller must hold the write
+// lock
+func (bs *Bitset) clearRange(start uint32, end uint32) {
+	startbyte := start >> 3
+	startbitpos := start & 7
+	endbyte := end >> 3
+	endbitpos := end & 7
+	bs.beforeWrite(startbyte, endbyte)
 	var i uint32 = startbyte
 	var andwith byte = 0
 	for {
@@ -243,23 +345,25 @@ func (bs *Bitset) ClearRange(start uint32, end uint32) error {
 		if i == endbyte && endbitpos != 7 {
 			andwith |= ^(0xff << (7 - endbitpos))
 		}
-		bs.buf[i] &= andwith
+		bs.buf[i] &= bs.msb(andwith)
 		if i >= endbyte {
 			break
 		}
 		i++
 		andwith = 0
 	}
-	return nil
+	bs.modified(OpReset, start, end)
 }
 
 // SetAll sets all the bits in the bitset to 1
 func (bs *Bitset) SetAll() {
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
+	bs.beforeWriteAll()
 	for i := uint32(0); i < bs.size; i++ {
 		bs.buf[i] = 255
 	}
+	bs.modifiedAll(OpSet)
 }
 
 // SetRange sets the bits in positions start <= position <= end. It returns non-nil error if
@@ -268,15 +372,23 @@ func (bs *Bitset) SetRange(start uint32, end uint32) error {
 	if start > end {
 		start, end = end, start
 	}
-	startbyte := start >> 3
-	startbitpos := start & 7
 	endbyte := end >> 3
-	endbitpos := end & 7
 	bs.mutex.Lock()
 	defer bs.mutex.Unlock()
 	if endbyte >= bs.size {
 		return ErrRange
 	}
+	bs.setRange(start, end)
+	return nil
+}
+
+// setRange does the work of SetRange on a validated range, the caller must hold the write lock
+func (bs *Bitset) setRange(start uint32, end uint32) {
+	startbyte := start >> 3
+	startbitpos := start & 7
+	endbyte := end >> 3
+	endbitpos := end & 7
+	bs.beforeWrite(startbyte, endbyte)
 	var i uint32 = startbyte
 	var orwith byte = 0xff
 	for {
@@ -286,14 +398,14 @@ func (bs *Bitset) SetRange(start uint32, end uint32) error {
 		if i == endbyte && endbitpos != 7 {
 			orwith &= 0xff << (7 - endbitpos)
 		}
-		bs.buf[i] |= orwith
+		bs.buf[i] |= bs.msb(orwith)
 		if i >= endbyte {
 			break
 		}
 		i++
 		orwith = 0xff
 	}
-	return nil
+	bs.modified(OpSet, start, end)
 }
 
 // GetBytes returns a clone of underlying byte array of the Bitset
@@ -320,7 +432,9 @@ func (bs *Bitset) Flip(position uint32) error {
 	if err != nil {
 		return err
 	}
+	bs.beforeWrite(bytepos, bytepos)
 	bs.buf[bytepos] ^= ones[bitpos]
+	bs.modified(OpFlip, position, position)
 	return nil
 }
 
@@ -339,6 +453,7 @@ func (bs *Bitset) FlipRange(start uint32, end uint32) error {
 	if endbyte >= bs.size {
 		return ErrRange
 	}
+	bs.beforeWrite(startbyte, endbyte)
 	var i uint32 = startbyte
 	var xorwith byte = 255
 	for {
@@ -348,16 +463,116 @@ func (bs *Bitset) FlipRange(start uint32, end uint32) error {
 		if i == endbyte && endbitpos != 7 {
 			xorwith &= 0xff << (7 - endbitpos)
 		}
-		bs.buf[i] ^= xorwith
+		bs.buf[i] ^= bs.msb(xorwith)
 		if i >= endbyte {
 			break
 		}
 		i++
 		xorwith = 255
 	}
+	bs.modified(OpFlip, start, end)
 	return nil
 }
 
+// TestAndSet sets the bit at position and returns the value the bit had before it was set.
+// The test and the set happen under a single lock acquisition, so only one of many goroutines
+// racing on the same bit will see false. Non-nil error is returned if position is out of range
+func (bs *Bitset) TestAndSet(position uint32) (bool, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bytepos, bitpos, err := bs.getBitBytePosition(position)
+	if err != nil {
+		return false, err
+	}
+	prev := bs.buf[bytepos] & ones[bitpos]
+	bs.beforeWrite(bytepos, bytepos)
+	bs.buf[bytepos] |= ones[bitpos]
+	bs.modified(OpSet, position, position)
+	return prev != 0, nil
+}
+
+// TestAndReset resets the bit at position and returns the value the bit had before it was reset.
+// Non-nil error is returned if position is out of range
+func (bs *Bitset) TestAndReset(position uint32) (bool, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bytepos, bitpos, err := bs.getBitBytePosition(position)
+	if err != nil {
+		return false, err
+	}
+	prev := bs.buf[bytepos] & ones[bitpos]
+	bs.beforeWrite(bytepos, bytepos)
+	bs.buf[bytepos] &= zeros[bitpos]
+	bs.modified(OpReset, position, position)
+	return prev != 0, nil
+}
+
+// TestAndFlip flips the bit at position and returns the value the bit had before it was flipped.
+// Non-nil error is returned if position is out of range
+func (bs *Bitset) TestAndFlip(position uint32) (bool, error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bytepos, bitpos, err := bs.getBitBytePosition(position)
+	if err != nil {
+		return false, err
+	}
+	prev := bs.buf[bytepos] & ones[bitpos]
+	bs.beforeWrite(bytepos, bytepos)
+	bs.buf[bytepos] ^= ones[bitpos]
+	bs.modified(OpFlip, position, position)
+	return prev != 0, nil
+}
+
+// TestAndSetRange sets the bits in positions start <= position <= end and returns how many of
+// them were zero before, i.e. how many bits actually changed. It returns non-nil error if any
+// of the position passed is out of range, in which case no bit is modified
+func (bs *Bitset) TestAndSetRange(start uint32, end uint32) (uint64, error) {
+	if start > end {
+		start, end = end, start
+	}
+	startbyte := start >> 3
+	endbyte := end >> 3
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if endbyte >= bs.size {
+		return 0, ErrRange
+	}
+	bs.beforeWrite(startbyte, endbyte)
+	var changed uint64 = 0
+	for i := startbyte; i <= endbyte; i++ {
+		mask := bs.msb(rangeMask(i, start, end))
+		changed += uint64(setbits[^bs.buf[i]&mask])
+		bs.buf[i] |= mask
+	}
+	bs.modified(OpSet, start, end)
+	return changed, nil
+}
+
+// TestAndResetRange resets the bits in positions start <= position <= end and returns how many
+// of them were set before, i.e. how many bits actually changed. It returns non-nil error if any
+// of the position passed is out of range, in which case no bit is modified
+func (bs *Bitset) TestAndResetRange(start uint32, end uint32) (uint64, error) {
+	if start > end {
+		start, end = end, start
+	}
+	startbyte := start >> 3
+	endbyte := end >> 3
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if endbyte >= bs.size {
+		return 0, ErrRange
+	}
+	bs.beforeWrite(startbyte, endbyte)
+	var changed uint64 = 0
+	for i := startbyte; i <= endbyte; i++ {
+		mask := bs.msb(rangeMask(i, start, end))
+		changed += uint64(setbits[bs.buf[i]&mask])
+		bs.buf[i] &= ^mask
+	}
+	bs.modified(OpReset, start, end)
+	return changed, nil
+}
+
 // IsAllZero returns true if all the bits in the set are zero
 func (bs *Bitset) IsAllZero() bool {
 	bs.mutex.RLock()
@@ -407,18 +622,28 @@ func (bs *Bitset) op(other *Bitset, opcode uint32) {
 	if i > other.size {
 		i = other.size
 	}
+	if i != 0 {
+		bs.beforeWrite(0, i-1)
+	}
 	var j uint32 = 0
 	for j < i {
+		b := other.buf[j]
+		if other.order != bs.order {
+			b = reversed[b]
+		}
 		switch opcode {
 		case and:
-			bs.buf[j] &= other.buf[j]
+			bs.buf[j] &= b
 		case or:
-			bs.buf[j] |= other.buf[j]
+			bs.buf[j] |= b
 		case xor:
-			bs.buf[j] ^= other.buf[j]
+			bs.buf[j] ^= b
 		}
 		j++
 	}
+	if i != 0 {
+		bs.modified(opchanges[opcode], 0, i<<3-1)
+	}
 }
 
 // GetSetbitCount returns the number of set or 1 bits in the bitset
@@ -435,6 +660,64 @@ func (bs *Bitset) GetZerobitCount() uint64 {
 // -1 is returned. Non-nil error status is returned when the passed position is exceeds the
 // highest bit position in the bit set
 func (bs *Bitset) GetNextSetBit(from_position uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.nextSetBit(from_position)
+}
+
+// GetNextZeroBit returns position of next zero bit after from_position. If no such bit exists,
+// -1 is returned. Non-nil error status is returned when the passed position is exceeds the
+// highest bit position in the bit set
+func (bs *Bitset) GetNextZeroBit(from_position uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.nextZeroBit(from_position)
+}
+
+// GetPrevZeroBit returns position of previous zero bit before from_position. If no such bit exists,
+// -1 is returned. If from_position exceeds the highest bit position, then non-null error is
+// returned
+func (bs *Bitset) GetPrevZeroBit(from_position uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.prevZeroBit(from_position)
+}
+
+// GetPrevSetBit returns position of previous set bit before from_position. If no such bit exists,
+// -1 is returned. If from_position exceeds the highest bit position, then non-null error is
+// returned
+func (bs *Bitset) GetPrevSetBit(from_position uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.prevSetBit(from_position)
+}
+
+// GetNextZeroRun returns the position of the first run of at least n consecutive zero bits
+// starting at or after from_position. If no such run exists, -1 is returned. Non-nil error is
+// returned when from_position exceeds the highest bit position in the bitset
+func (bs *Bitset) GetNextZeroRun(from_position uint32, n uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if from_position>>3 >= bs.size {
+		return -1, ErrRange
+	}
+	return bs.findRun(from_position, n, false), nil
+}
+
+// GetNextSetRun returns the position of the first run of at least n consecutive set bits
+// starting at or after from_position. If no such run exists, -1 is returned. Non-nil error is
+// returned when from_position exceeds the highest bit position in the bitset
+func (bs *Bitset) GetNextSetRun(from_position uint32, n uint32) (int64, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if from_position>>3 >= bs.size {
+		return -1, ErrRange
+	}
+	return bs.findRun(from_position, n, true), nil
+}
+
+// nextSetBit does the work of GetNextSetBit, the caller must hold the lock
+func (bs *Bitset) nextSetBit(from_position uint32) (int64, error) {
 	bit_pos := (from_position & 7)
 	var from_byte uint32 = 0
 	if bit_pos == 7 {
@@ -442,32 +725,28 @@ func (bs *Bitset) GetNextSetBit(from_position uint32) (int64, error) {
 	} else {
 		from_byte = (from_position >> 3)
 	}
-	bs.mutex.RLock()
-	defer bs.mutex.RUnlock()
 	if from_byte >= bs.size {
 		return -1, ErrRange
 	}
 	i := from_byte
 	if bit_pos != 7 {
-		tmp := (byte(255) >> (bit_pos + 1)) & bs.buf[i]
+		tmp := (byte(255) >> (bit_pos + 1)) & bs.msb(bs.buf[i])
 		if leftm1[tmp] != 8 {
 			return int64(from_byte<<3) + int64(7-leftm1[tmp]), nil
 		}
 		i++
 	}
 	for i < bs.size {
-		if leftm1[bs.buf[i]] != 8 {
-			return int64(i<<3) + int64(7-leftm1[bs.buf[i]]), nil
+		if b := bs.msb(bs.buf[i]); leftm1[b] != 8 {
+			return int64(i<<3) + int64(7-leftm1[b]), nil
 		}
 		i++
 	}
 	return -1, nil
 }
 
-// GetNextZeroBit returns position of next zero bit after from_position. If no such bit exists,
-// -1 is returned. Non-nil error status is returned when the passed position is exceeds the
-// highest bit position in the bit set
-func (bs *Bitset) GetNextZeroBit(from_position uint32) (int64, error) {
+// nextZeroBit does the work of GetNextZeroBit, the caller must hold the lock
+func (bs *Bitset) nextZeroBit(from_position uint32) (int64, error) {
 	bit_pos := (from_position & 7)
 	var from_byte uint32 = 0
 	if bit_pos == 7 {
@@ -475,32 +754,28 @@ func (bs *Bitset) GetNextZeroBit(from_position uint32) (int64, error) {
 	} else {
 		from_byte = (from_position >> 3)
 	}
-	bs.mutex.RLock()
-	defer bs.mutex.RUnlock()
 	if from_byte >= bs.size {
 		return -1, ErrRange
 	}
 	i := from_byte
 	if bit_pos != 7 {
-		tmp := (byte(255) << (7 - bit_pos)) | bs.buf[i]
+		tmp := (byte(255) << (7 - bit_pos)) | bs.msb(bs.buf[i])
 		if leftmz[tmp] != 8 {
 			return int64(from_byte<<3) + int64(7-leftmz[tmp]), nil
 		}
 		i++
 	}
 	for i < bs.size {
-		if leftmz[bs.buf[i]] != 8 {
-			return int64(i<<3) + int64(7-leftmz[bs.buf[i]]), nil
+		if b := bs.msb(bs.buf[i]); leftmz[b] != 8 {
+			return int64(i<<3) + int64(7-leftmz[b]), nil
 		}
 		i++
 	}
 	return -1, nil
 }
 
-// GetPrevZeroBit returns position of previous zero bit before from_position. If no such bit exists,
-// -1 is returned. If from_position exceeds the highest bit position, then non-null error is
-// returned
-func (bs *Bitset) GetPrevZeroBit(from_position uint32) (int64, error) {
+// prevZeroBit does the work of GetPrevZeroBit, the caller must hold the lock
+func (bs *Bitset) prevZeroBit(from_position uint32) (int64, error) {
 	if from_position == 0 {
 		return -1, nil
 	}
@@ -511,14 +786,12 @@ func (bs *Bitset) GetPrevZeroBit(from_position uint32) (int64, error) {
 	} else {
 		from_byte = (from_position >> 3)
 	}
-	bs.mutex.RLock()
-	defer bs.mutex.RUnlock()
 	if from_byte >= bs.size {
 		return -1, ErrRange
 	}
 	i := from_byte
 	if bit_pos != 0 {
-		tmp := (byte(255) >> bit_pos) | bs.buf[i]
+		tmp := (byte(255) >> bit_pos) | bs.msb(bs.buf[i])
 		if rightmz[tmp] != 8 {
 			return int64(from_byte<<3) + int64(7-rightmz[tmp]), nil
 		}
@@ -528,8 +801,8 @@ func (bs *Bitset) GetPrevZeroBit(from_position uint32) (int64, error) {
 		i--
 	}
 	for {
-		if rightmz[bs.buf[i]] != 8 {
-			return int64(i<<3) + int64(7-rightmz[bs.buf[i]]), nil
+		if b := bs.msb(bs.buf[i]); rightmz[b] != 8 {
+			return int64(i<<3) + int64(7-rightmz[b]), nil
 		}
 		if i == 0 {
 			break
@@ -539,10 +812,8 @@ func (bs *Bitset) GetPrevZeroBit(from_position uint32) (int64, error) {
 	return -1, nil
 }
 
-// GetPrevSetBit returns position of previous set bit before from_position. If no such bit exists,
-// -1 is returned. If from_position exceeds the highest bit position, then non-null error is
-// returned
-func (bs *Bitset) GetPrevSetBit(from_position uint32) (int64, error) {
+// prevSetBit does the work of GetPrevSetBit, the caller must hold the lock
+func (bs *Bitset) prevSetBit(from_position uint32) (int64, error) {
 	if from_position == 0 {
 		return -1, nil
 	}
@@ -553,14 +824,12 @@ func (bs *Bitset) GetPrevSetBit(from_position uint32) (int64, error) {
 	} else {
 		from_byte = (from_position >> 3)
 	}
-	bs.mutex.RLock()
-	defer bs.mutex.RUnlock()
 	if from_byte >= bs.size {
 		return -1, ErrRange
 	}
 	i := from_byte
 	if bit_pos != 0 {
-		tmp := (byte(255) << (7 - bit_pos + 1)) & bs.buf[i]
+		tmp := (byte(255) << (7 - bit_pos + 1)) & bs.msb(bs.buf[i])
 		if rightm1[tmp] != 8 {
 			return int64(from_byte<<3) + int64(7-rightm1[tmp]), nil
 		}
@@ -570,8 +839,8 @@ func (bs *Bitset) GetPrevSetBit(from_position uint32) (int64, error) {
 		i--
 	}
 	for {
-		if rightm1[bs.buf[i]] != 8 {
-			return int64(i<<3) + int64(7-rightm1[bs.buf[i]]), nil
+		if b := bs.msb(bs.buf[i]); rightm1[b] != 8 {
+			return int64(i<<3) + int64(7-rightm1[b]), nil
 		}
 		if i == 0 {
 			break
@@ -581,20 +850,147 @@ func (bs *Bitset) GetPrevSetBit(from_position uint32) (int64, error) {
 	return -1, nil
 }
 
+// resize does the work of Resize, the caller must hold the write lock
+func (bs *Bitset) resize(newsize uint32) error {
+	var newbf []byte
+	var err error
+	if bs.mapping != nil {
+		// the old mapping goes away, so the snapshots must stop sharing it
+		bs.detachSnapshots()
+		if newbf, err = bs.mapping.resize(newsize); err != nil {
+			// the size did not change unless the file could not be mapped again at all
+			if uint32(len(newbf)) == bs.size {
+				bs.buf = newbf
+				return err
+			}
+			newsize = uint32(len(newbf))
+		}
+	} else {
+		newbf = make([]byte, newsize)
+		copy(newbf, bs.buf)
+	}
+	if bs.dirty != nil {
+		bs.dirty.resize(bs.size, newsize)
+	}
+	bs.detachSnapshots()
+	bs.size = newsize
+	bs.buf = newbf
+	bs.modified(OpResize, 0, 0)
+	return err
+}
+
+// nextZeroFrom returns the position of the first zero bit at or after position, -1 if there is
+// no such bit. The caller must hold the lock
+func (bs *Bitset) nextZeroFrom(position uint32) int64 {
+	i := position >> 3
+	if i >= bs.size {
+		return -1
+	}
+	tmp := bs.msb(bs.buf[i]) | ^byte(0xff>>(position&7))
+	for {
+		if leftmz[tmp] != 8 {
+			return int64(i)<<3 + int64(7-leftmz[tmp])
+		}
+		i++
+		if i >= bs.size {
+			return -1
+		}
+		tmp = bs.msb(bs.buf[i])
+	}
+}
+
+// findRun returns the start of the first run of at least n bits equal to val at or after
+// position, -1 if there is no such run. The caller must hold the lock
+func (bs *Bitset) findRun(position uint32, n uint32, val bool) int64 {
+	var want, other byte = 0, 0xff
+	if val {
+		want, other = 0xff, 0
+	}
+	nbits := uint64(bs.size) << 3
+	var runstart, runlen uint64 = 0, 0
+	p := uint64(position)
+	for p < nbits {
+		b := bs.buf[p>>3]
+		if p&7 == 0 && (b == want || b == other) {
+			// whole byte either extends or breaks the run
+			if b == other {
+				runlen = 0
+			} else {
+				if runlen == 0 {
+					runstart = p
+				}
+				runlen += 8
+			}
+			p += 8
+		} else {
+			if (b&ones[bs.bitpos(uint32(p))] != 0) == val {
+				if runlen == 0 {
+					runstart = p
+				}
+				runlen++
+			} else {
+				runlen = 0
+			}
+			p++
+		}
+		if runlen != 0 && runlen >= uint64(n) {
+			return int64(runstart)
+		}
+	}
+	return -1
+}
+
 // getBitBytePosition returns the corresponding byte position and bit position within the byte
 // for the absolute bit position passed
 func (bs *Bitset) getBitBytePosition(position uint32) (uint32, uint32, error) {
 	bytepos := position >> 3
-	bitpos := 7 - (position & 7)
+	bitpos := bs.bitpos(position)
 	if bytepos >= bs.size {
 		return 0, 0, ErrRange
 	}
 	return bytepos, bitpos, nil
 }
 
+// bitpos returns the index in ones and zeros of the bit at position within its byte
+func (bs *Bitset) bitpos(position uint32) uint32 {
+	if bs.order == LSBFirst {
+		return position & 7
+	}
+	return 7 - (position & 7)
+}
+
+// msb returns b, a byte of the bitset, with its bits in MSB-first order, so that the lookup
+// tables and masks numbering the bits from the most significant one apply to it. Reversing
+// the bits of a byte of a LSB-first bitset twice gives it back, so msb also turns such a byte
+// or mask back into the order of the bitset
+func (bs *Bitset) msb(b byte) byte {
+	return orderByte(b, bs.order)
+}
+
+// lsb returns b, a byte of the bitset, with its bits in LSB-first order, in which the fields
+// starting with their least significant bit are assembled. As msb, it also turns such a byte
+// back into the order of the bitset
+func (bs *Bitset) lsb(b byte) byte {
+	return reversed[bs.msb(b)]
+}
+
+// orderByte returns b with its bits reversed if order is LSBFirst, turning a byte whose bits
+// are numbered in order into MSB-first order and back
+func orderByte(b byte, order BitOrder) byte {
+	if order == LSBFirst {
+		return reversed[b]
+	}
+	return b
+}
+
 func (bs *Bitset) getSetbitc() uint64 {
 	bs.mutex.RLock()
 	defer bs.mutex.RUnlock()
+	return bs.setbitc()
+}
+
+// setbitc counts the set bits, the caller must hold the lock
+func (bs *Bitset) setbitc() uint64 {
 	var ret uint64 = 0
 	var i uint32 = 0
 	for i < bs.size {
@@ -603,3 +999,16 @@ func (bs *Bitset) getSetbitc() uint64 {
 	}
 	return ret
 }
+
+// rangeMask returns the mask of the bits within byte i that fall in positions
+// start <= position <= end
+func rangeMask(i uint32, start uint32, end uint32) byte {
+	var mask byte = 0xff
+	if i == start>>3 {
+		mask >>= start & 7
+	}
+	if i == end>>3 {
+		mask &= 0xff << (7 - (end & 7))
+	}
+	return mask
+}
@@ -1,6 +1,7 @@
 package bitset
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -408,6 +409,15 @@ func TestSetReset(t *testing.T) {
 	if err != nil || retuint32 != 0xffffffff {
 		t.Fatalf("GetVal failed, expected value 0xffffffff, got %d, %v", retuint32, err)
 	}
+	bs.ClearAll()
+	bs.SetVal(4, 35, 0xffffffff)
+	retuint32, err = bs.GetVal(4, 35)
+	if err != nil || retuint32 != 0xffffffff {
+		t.Fatalf("GetVal failed, expected value 0xffffffff, got %x, %v", retuint32, err)
+	}
+	if _, err = bs.GetVal(780, 800); err != ErrRange {
+		t.Fatalf("GetVal failed to detect invalid range, got %v", err)
+	}
 }
 
 func TestBitSetClone(t *testing.T) {
@@ -424,3 +434,292 @@ func TestBitSetClone(t *testing.T) {
 		t.Fatal("Clone failed")
 	}
 }
+
+func TestTestAndModify(t *testing.T) {
+	bs := NewBitset(10)
+	prev, err := bs.TestAndSet(13)
+	if err != nil || prev {
+		t.Fatalf("TestAndSet failed, expected false, got %v, %v", prev, err)
+	}
+	prev, err = bs.TestAndSet(13)
+	if err != nil || !prev {
+		t.Fatalf("TestAndSet failed, expected true, got %v, %v", prev, err)
+	}
+	prev, err = bs.TestAndReset(13)
+	if err != nil || !prev {
+		t.Fatalf("TestAndReset failed, expected true, got %v, %v", prev, err)
+	}
+	prev, err = bs.TestAndReset(13)
+	if err != nil || prev {
+		t.Fatalf("TestAndReset failed, expected false, got %v, %v", prev, err)
+	}
+	prev, err = bs.TestAndFlip(13)
+	if err != nil || prev {
+		t.Fatalf("TestAndFlip failed, expected false, got %v, %v", prev, err)
+	}
+	if ret, _ := bs.IsSet(13); !ret {
+		t.Fatal("TestAndFlip failed to flip the bit")
+	}
+	if _, err = bs.TestAndSet(80); err != ErrRange {
+		t.Fatal("TestAndSet failed to detect invalid position")
+	}
+
+	bs.ClearAll()
+	bs.SetBit(5)
+	bs.SetBit(20)
+	changed, err := bs.TestAndSetRange(3, 22)
+	if err != nil || changed != 18 {
+		t.Fatalf("TestAndSetRange failed, expected 18, got %d, %v", changed, err)
+	}
+	if bs.GetSetbitCount() != 20 {
+		t.Fatalf("TestAndSetRange failed, expected 20 set bits, got %d", bs.GetSetbitCount())
+	}
+	changed, err = bs.TestAndResetRange(0, 10)
+	if err != nil || changed != 8 {
+		t.Fatalf("TestAndResetRange failed, expected 8, got %d, %v", changed, err)
+	}
+	if _, err = bs.TestAndSetRange(70, 80); err != ErrRange {
+		t.Fatal("TestAndSetRange failed to detect invalid range")
+	}
+
+	// many goroutines racing for the same bits, every bit must be claimed exactly once
+	bs.ClearAll()
+	claimed := make(chan uint32, 80)
+	done := make(chan bool)
+	for g := 0; g < 8; g++ {
+		go func() {
+			for pos := uint32(0); pos < 80; pos++ {
+				if prev, _ := bs.TestAndSet(pos); !prev {
+					claimed <- pos
+				}
+			}
+			done <- true
+		}()
+	}
+	for g := 0; g < 8; g++ {
+		<-done
+	}
+	close(claimed)
+	count := 0
+	for range claimed {
+		count++
+	}
+	if count != 80 {
+		t.Fatalf("TestAndSet failed, expected 80 claims, got %d", count)
+	}
+}
+
+func TestRunSearch(t *testing.T) {
+	bs := NewBitset(8)
+	bs.SetAll()
+	bs.ClearRange(5, 7)
+	bs.ClearRange(20, 35)
+	indx, err := bs.GetNextZeroRun(0, 3)
+	if err != nil || indx != 5 {
+		t.Fatalf("GetNextZeroRun failed, expected 5, got %d, %v", indx, err)
+	}
+	indx, err = bs.GetNextZeroRun(0, 4)
+	if err != nil || indx != 20 {
+		t.Fatalf("GetNextZeroRun failed, expected 20, got %d, %v", indx, err)
+	}
+	indx, err = bs.GetNextZeroRun(25, 11)
+	if err != nil || indx != 25 {
+		t.Fatalf("GetNextZeroRun failed, expected 25, got %d, %v", indx, err)
+	}
+	indx, err = bs.GetNextZeroRun(0, 17)
+	if err != nil || indx != -1 {
+		t.Fatalf("GetNextZeroRun failed, expected -1, got %d, %v", indx, err)
+	}
+	indx, err = bs.GetNextSetRun(5, 12)
+	if err != nil || indx != 8 {
+		t.Fatalf("GetNextSetRun failed, expected 8, got %d, %v", indx, err)
+	}
+	indx, err = bs.GetNextSetRun(0, 28)
+	if err != nil || indx != 36 {
+		t.Fatalf("GetNextSetRun failed, expected 36, got %d, %v", indx, err)
+	}
+	if _, err = bs.GetNextSetRun(64, 1); err != ErrRange {
+		t.Fatal("GetNextSetRun failed to detect invalid position")
+	}
+}
+
+func TestGetValConcurrent(t *testing.T) {
+	bs := NewBitset(8)
+	var writers, readers sync.WaitGroup
+	stop := make(chan struct{})
+	writers.Add(2)
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 2000; i++ {
+			if i%2 == 0 {
+				bs.SetVal(4, 35, 0xffffffff)
+			} else {
+				bs.SetVal(4, 35, 0)
+			}
+		}
+	}()
+	go func() {
+		defer writers.Done()
+		for i := 0; i < 500; i++ {
+			bs.Resize(2)
+			bs.Resize(8)
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// a shrunk bitset is reported, never read past its end
+				v, err := bs.GetVal(4, 35)
+				if err != nil && err != ErrRange {
+					t.Errorf("GetVal failed %v", err)
+					return
+				}
+				// shrinking to 2 bytes and growing back clears the bits from 16
+				if err == nil && v != 0 && v != 0xffffffff && v != 0xfff00000 {
+					t.Errorf("GetVal failed, read a torn value %x", v)
+					return
+				}
+				bs.GetVal64(0, 63)
+				bs.ReadBits(3, 50)
+			}
+		}()
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+}
+
+func TestBitOrder(t *testing.T) {
+	bs := NewBitsetWithOrder(4, LSBFirst)
+	if bs.Order() != LSBFirst || NewBitset(1).Order() != MSBFirst {
+		t.Fatal("Order failed")
+	}
+	bs.SetBit(0)
+	bs.SetBit(9)
+	bs.SetRange(20, 26)
+	if b := bs.GetBytes(); b[0] != 0x01 || b[1] != 0x02 || b[2] != 0xf0 || b[3] != 0x07 {
+		t.Fatalf("SetBit failed, got bytes %x", b)
+	}
+	if v, _ := bs.GetVal(8, 11); v != 4 {
+		t.Fatalf("GetVal failed, got %d", v)
+	}
+	converted := bs.ConvertOrder(MSBFirst)
+	if b := converted.GetBytes(); b[0] != 0x80 || b[1] != 0x40 || b[2] != 0x0f || b[3] != 0xe0 {
+		t.Fatalf("ConvertOrder failed, got bytes %x", b)
+	}
+	if back := converted.ConvertOrder(LSBFirst); string(back.GetBytes()) != string(bs.GetBytes()) {
+		t.Fatal("ConvertOrder failed to convert back")
+	}
+	shared := []byte{0x81}
+	if ok, _ := NewBitsetFromArrayWithOrder(shared, LSBFirst).IsSet(7); !ok {
+		t.Fatal("NewBitsetFromArrayWithOrder failed")
+	}
+
+	// every method gives the same answers whatever the order
+	lsb := NewBitsetWithOrder(16, LSBFirst)
+	msb := NewBitset(16)
+	check := func(step string) {
+		if string(lsb.ConvertOrder(MSBFirst).GetBytes()) != string(msb.GetBytes()) {
+			t.Fatalf("%s failed, bitsets differ", step)
+		}
+		for p := uint32(0); p < 128; p += 7 {
+			a1, _ := lsb.GetNextSetBit(p)
+			b1, _ := msb.GetNextSetBit(p)
+			a2, _ := lsb.GetNextZeroBit(p)
+			b2, _ := msb.GetNextZeroBit(p)
+			a3, _ := lsb.GetPrevSetBit(p)
+			b3, _ := msb.GetPrevSetBit(p)
+			a4, _ := lsb.GetPrevZeroBit(p)
+			b4, _ := msb.GetPrevZeroBit(p)
+			a5, _ := lsb.GetNextZeroRun(p, 5)
+			b5, _ := msb.GetNextZeroRun(p, 5)
+			if a1 != b1 || a2 != b2 || a3 != b3 || a4 != b4 || a5 != b5 {
+				t.Fatalf("%s failed, searches from %d differ", step, p)
+			}
+			if p+20 < 128 {
+				a6, _ := lsb.GetVal(p, p+20)
+				b6, _ := msb.GetVal(p, p+20)
+				a7, _ := lsb.GetVal64(p, p+7)
+				b7, _ := msb.GetVal64(p, p+7)
+				a8, _ := lsb.ReadBits(p, 20)
+				b8, _ := msb.ReadBits(p, 20)
+				if a6 != b6 || a7 != b7 || string(a8) != string(b8) {
+					t.Fatalf("%s failed, values at %d differ", step, p)
+				}
+			}
+		}
+		fl, fm := lsb.Freeze(), msb.Freeze()
+		var al, am []uint32
+		fl.ForEachSetBit(func(p uint32) bool { al = append(al, p); return true })
+		fm.ForEachSetBit(func(p uint32) bool { am = append(am, p); return true })
+		if len(al) != len(am) || fl.Thaw().Order() != LSBFirst {
+			t.Fatalf("%s failed, frozen bitsets differ", step)
+		}
+		for i := range al {
+			if al[i] != am[i] {
+				t.Fatalf("%s failed, frozen bitsets differ", step)
+			}
+		}
+		if pl, _ := lsb.Persistent().IsSet(al[0]); len(al) != 0 && !pl {
+			t.Fatalf("%s failed, persistent bitset differs", step)
+		}
+	}
+	for _, bs := range []*Bitset{lsb, msb} {
+		bs.SetBit(3)
+		bs.SetRange(10, 45)
+		bs.ResetBit(12)
+		bs.Flip(70)
+		bs.FlipRange(40, 52)
+	}
+	check("SetBit, SetRange, ResetBit and Flip")
+	for _, bs := range []*Bitset{lsb, msb} {
+		bs.ClearRange(20, 23)
+		bs.SetVal(57, 80, 0xabcdef)
+		bs.SetVal64(90, 127, 0x1234567890)
+		bs.WriteBits(81, []byte{0xa5, 0x5a}, 13)
+	}
+	check("ClearRange, SetVal and WriteBits")
+	for _, bs := range []*Bitset{lsb, msb} {
+		bs.TestAndSet(100)
+		bs.TestAndReset(101)
+		bs.TestAndFlip(5)
+		bs.TestAndSetRange(1, 9)
+		bs.TestAndResetRange(60, 66)
+	}
+	check("TestAnd operations")
+	other := NewBitset(16)
+	other.SetRange(30, 90)
+	lsb.Xor(other)
+	msb.Xor(other)
+	check("Xor with a bitset of the other order")
+
+	snap := lsb.Snapshot()
+	defer snap.Release()
+	for p := uint32(0); p < 128; p++ {
+		a, _ := snap.IsSet(p)
+		b, _ := msb.IsSet(p)
+		if a != b {
+			t.Fatalf("Snapshot failed at %d", p)
+		}
+	}
+	w := NewBitWriter(NewBitsetWithOrder(0, LSBFirst))
+	w.WriteGamma(9)
+	w.WriteUnary(3)
+	if b := w.Bitset().GetBytes(); b[0] != 0xc8 || b[1] != 0x03 {
+		t.Fatalf("BitWriter failed, got bytes %x", b)
+	}
+	r := w.Reader()
+	if v, _ := r.ReadGamma(); v != 9 {
+		t.Fatalf("BitReader failed, got %d", v)
+	}
+	if v, _ := r.ReadUnary(); v != 3 {
+		t.Fatalf("BitReader failed, got %d", v)
+	}
+}
@@ -0,0 +1,323 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// BitWriter appends bits to a Bitset, most significant bit first, growing it as needed. On
+// top of fixed width values it writes unary, Elias-gamma and Golomb-Rice codes, which suit
+// small numbers such as the gaps between sorted IDs. It is not safe to share a BitWriter
+// between goroutines, but other goroutines may use the bitset it writes to.
+type BitWriter struct {
+	bs  *Bitset
+	pos uint32
+}
+
+// BitReader reads bits sequentially from a Bitset, most significant bit first, and decodes
+// the codes written by BitWriter. It is not safe to share a BitReader between goroutines.
+type BitReader struct {
+	bs  *Bitset
+	pos uint32
+	end uint32
+}
+
+// NewBitWriter returns a writer appending bits to bs from its first bit, overwriting its
+// content. A new empty bitset is used if bs is nil
+func NewBitWriter(bs *Bitset) *BitWriter {
+	if bs == nil {
+		bs = NewBitset(0)
+	}
+	return &BitWriter{bs: bs}
+}
+
+// Bitset returns the bitset the writer writes to
+func (w *BitWriter) Bitset() *Bitset {
+	return w.bs
+}
+
+// Len returns the number of bits written
+func (w *BitWriter) Len() uint32 {
+	return w.pos
+}
+
+// Reader returns a reader of the bits written so far
+func (w *BitWriter) Reader() *BitReader {
+	return &BitReader{bs: w.bs, end: w.pos}
+}
+
+// WriteBits appends the lowest n bits of v, most significant first. ErrMaxR is returned if n
+// is larger than 64, ErrRange if the bitset can't grow any more
+func (w *BitWriter) WriteBits(v uint64, n uint32) error {
+	if n > 64 {
+		return ErrMaxR
+	}
+	if n == 0 {
+		return nil
+	}
+	bs := w.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if err := w.grow(uint64(n)); err != nil {
+		return err
+	}
+	w.putBits(v, n)
+	return nil
+}
+
+// WriteBit appends one bit
+func (w *BitWriter) WriteBit(bit bool) error {
+	if bit {
+		return w.WriteBits(1, 1)
+	}
+	return w.WriteBits(0, 1)
+}
+
+// WriteUnary appends n as n one bits followed by a zero bit. ErrRange is returned if the
+// bitset can't grow enough
+func (w *BitWriter) WriteUnary(n uint64) error {
+	if n >= 0xffffffff {
+		return ErrRange
+	}
+	bs := w.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if err := w.grow(n + 1); err != nil {
+		return err
+	}
+	w.putUnary(n)
+	return nil
+}
+
+// WriteGamma appends the Elias-gamma code of n, the number of bits of n less one as zero bits
+// followed by n in binary. ErrRange is returned if n is 0, which has no code, or if the bitset
+// can't grow enough, in which case nothing is written
+func (w *BitWriter) WriteGamma(n uint64) error {
+	if n == 0 {
+		return ErrRange
+	}
+	numbits := uint32(bits.Len64(n))
+	bs := w.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if err := w.grow(uint64(2*numbits - 1)); err != nil {
+		return err
+	}
+	w.putBits(0, numbits-1)
+	w.putBits(n, numbits)
+	return nil
+}
+
+// WriteRice appends the Golomb-Rice code of v with parameter k, the quotient v>>k in unary
+// followed by the lowest k bits of v. ErrMaxR is returned if k is larger than 64, ErrRange if
+// the bitset can't grow enough, in which case nothing is written
+func (w *BitWriter) WriteRice(v uint64, k uint32) error {
+	if k > 64 {
+		return ErrMaxR
+	}
+	q := uint64(0)
+	if k < 64 {
+		q = v >> k
+	}
+	if q >= 0xffffffff {
+		return ErrRange
+	}
+	bs := w.bs
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if err := w.grow(q + 1 + uint64(k)); err != nil {
+		return err
+	}
+	w.putUnary(q)
+	w.putBits(v, k)
+	return nil
+}
+
+// putBits appends the lowest n bits of v, n being at most 64, once the bitset has grown
+// enough. The caller must hold the write lock
+func (w *BitWriter) putBits(v uint64, n uint32) {
+	if n == 0 {
+		return
+	}
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], v<<(64-n))
+	w.bs.writeBits(w.pos, data[:], n, w.bs.order)
+	w.pos += n
+}
+
+// putUnary appends n in unary once the bitset has grown enough. The caller must hold the
+// write lock
+func (w *BitWriter) putUnary(n uint64) {
+	bs := w.bs
+	if n != 0 {
+		bs.setRange(w.pos, w.pos+uint32(n)-1)
+	}
+	bs.writeBits(w.pos+uint32(n), []byte{0}, 1, bs.order)
+	w.pos += uint32(n) + 1
+}
+
+// grow makes room for n more bits, at least doubling the bitset. ErrRange is returned if the
+// position after them doesn't fit in a uint32. The caller must hold the write lock
+func (w *BitWriter) grow(n uint64) error {
+	end := uint64(w.pos) + n
+	if end > 0xffffffff {
+		return ErrRange
+	}
+	needed := (end + 7) >> 3
+	if needed <= uint64(w.bs.size) {
+		return nil
+	}
+	if needed > maxAddressableBytes {
+		return ErrRange
+	}
+	newsize := uint64(w.bs.size) * 2
+	if newsize < needed {
+		newsize = needed
+	}
+	if newsize > maxAddressableBytes {
+		newsize = maxAddressableBytes
+	}
+	return w.bs.resize(uint32(newsize))
+}
+
+// NewBitReader returns a reader of all the bits of bs from its first bit
+func NewBitReader(bs *Bitset) *BitReader {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	end := uint64(bs.size) << 3
+	if end > 0xffffffff {
+		end = 0xffffffff
+	}
+	return &BitReader{bs: bs, end: uint32(end)}
+}
+
+// Position returns the position of the next bit to read
+func (r *BitReader) Position() uint32 {
+	return r.pos
+}
+
+// Remaining returns the number of bits left to read
+func (r *BitReader) Remaining() uint32 {
+	return r.end - r.pos
+}
+
+// Seek moves the reader to position. ErrRange is returned if position is beyond the bits to
+// read
+func (r *BitReader) Seek(position uint32) error {
+	if position > r.end {
+		return ErrRange
+	}
+	r.pos = position
+	return nil
+}
+
+// ReadBits reads n bits and returns them right adjusted, the first one most significant.
+// ErrMaxR is returned if n is larger than 64. io.EOF is returned if there are no bits left,
+// io.ErrUnexpectedEOF if there are less than n, in which case nothing is read
+func (r *BitReader) ReadBits(n uint32) (uint64, error) {
+	if n > 64 {
+		return 0, ErrMaxR
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if err := r.check(uint64(n)); err != nil {
+		return 0, err
+	}
+	bs := r.bs
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if (uint64(r.pos)+uint64(n)-1)>>3 >= uint64(bs.size) {
+		// the bitset shrank
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := bs.getVal64(r.pos, r.pos+n-1, bs.order)
+	r.pos += n
+	return v, nil
+}
+
+// ReadBit reads one bit
+func (r *BitReader) ReadBit() (bool, error) {
+	v, err := r.ReadBits(1)
+	return v != 0, err
+}
+
+// ReadUnary reads a number written by WriteUnary
+func (r *BitReader) ReadUnary() (uint64, error) {
+	if err := r.check(1); err != nil {
+		return 0, err
+	}
+	bs := r.bs
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	zero := bs.nextZeroFrom(r.pos)
+	if zero < 0 || zero >= int64(r.end) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := uint64(zero) - uint64(r.pos)
+	r.pos = uint32(zero) + 1
+	return n, nil
+}
+
+// ReadGamma reads a number written by WriteGamma
+func (r *BitReader) ReadGamma() (uint64, error) {
+	start := r.pos
+	zeros := uint32(0)
+	for {
+		bit, err := r.ReadBit()
+		if err != nil {
+			r.pos = start
+			if err == io.EOF && zeros != 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if bit {
+			break
+		}
+		zeros++
+		if zeros > 63 {
+			r.pos = start
+			return 0, ErrRange
+		}
+	}
+	rest, err := r.ReadBits(zeros)
+	if err != nil {
+		r.pos = start
+		return 0, io.ErrUnexpectedEOF
+	}
+	return 1<<zeros | rest, nil
+}
+
+// ReadRice reads a number written by WriteRice with parameter k
+func (r *BitReader) ReadRice(k uint32) (uint64, error) {
+	if k > 64 {
+		return 0, ErrMaxR
+	}
+	start := r.pos
+	q, err := r.ReadUnary()
+	if err != nil {
+		return 0, err
+	}
+	rest, err := r.ReadBits(k)
+	if err != nil {
+		r.pos = start
+		return 0, io.ErrUnexpectedEOF
+	}
+	if k == 64 {
+		return rest, nil
+	}
+	return q<<k | rest, nil
+}
+
+// check returns the error for reading n more bits if there are not enough left
+func (r *BitReader) check(n uint64) error {
+	if r.pos >= r.end {
+		return io.EOF
+	}
+	if uint64(r.pos)+n > uint64(r.end) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package bitset
+
+import (
+	"io"
+	"testing"
+)
+
+func TestBitWriterReader(t *testing.T) {
+	w := NewBitWriter(nil)
+	w.WriteBits(0x5, 3)
+	w.WriteBit(true)
+	w.WriteBits(0xdeadbeefcafebabe, 64)
+	w.WriteUnary(0)
+	w.WriteUnary(20)
+	w.WriteGamma(1)
+	w.WriteGamma(17)
+	w.WriteRice(77, 4)
+	w.WriteRice(3, 0)
+	if err := w.WriteGamma(0); err != ErrRange {
+		t.Fatal("WriteGamma failed to reject 0")
+	}
+	if err := w.WriteBits(0, 65); err != ErrMaxR {
+		t.Fatal("WriteBits failed to reject 65 bits")
+	}
+	for _, n := range []uint64{1<<32 - 1, 1 << 32, 1<<64 - 1} {
+		if err := w.WriteUnary(n); err != ErrRange {
+			t.Fatalf("WriteUnary failed to reject %d", n)
+		}
+	}
+
+	// a code whose first part fits but not the rest is not written at all
+	full := NewBitWriter(nil)
+	full.pos = 0xffffffff - 10
+	if full.WriteGamma(255) != ErrRange || full.WriteRice(3<<8, 8) != ErrRange ||
+		full.Len() != 0xffffffff-10 {
+		t.Fatalf("WriteGamma or WriteRice failed to write nothing, length %d", full.Len())
+	}
+	// 3 + 1 + 64 + 1 + 21 + 1 + 9 + 4+1+4 + 3+1
+	if w.Len() != 113 || w.Bitset().GetSize() < 15 {
+		t.Fatalf("BitWriter failed, wrote %d bits in %d bytes", w.Len(), w.Bitset().GetSize())
+	}
+
+	r := w.Reader()
+	if v, err := r.ReadBits(3); err != nil || v != 5 {
+		t.Fatalf("ReadBits failed, got %d %v", v, err)
+	}
+	if bit, _ := r.ReadBit(); !bit {
+		t.Fatal("ReadBit failed")
+	}
+	if v, _ := r.ReadBits(64); v != 0xdeadbeefcafebabe {
+		t.Fatalf("ReadBits failed, got %x", v)
+	}
+	if v, _ := r.ReadUnary(); v != 0 {
+		t.Fatalf("ReadUnary failed, got %d", v)
+	}
+	if v, _ := r.ReadUnary(); v != 20 {
+		t.Fatalf("ReadUnary failed, got %d", v)
+	}
+	if v, _ := r.ReadGamma(); v != 1 {
+		t.Fatalf("ReadGamma failed, got %d", v)
+	}
+	if v, _ := r.ReadGamma(); v != 17 {
+		t.Fatalf("ReadGamma failed, got %d", v)
+	}
+	if v, _ := r.ReadRice(4); v != 77 {
+		t.Fatalf("ReadRice failed, got %d", v)
+	}
+	if r.Remaining() != 4 {
+		t.Fatalf("Remaining failed, got %d", r.Remaining())
+	}
+	if _, err := r.ReadBits(5); err != io.ErrUnexpectedEOF || r.Position() != 109 {
+		t.Fatalf("ReadBits failed to detect truncated read, got %v", err)
+	}
+	if v, _ := r.ReadRice(0); v != 3 {
+		t.Fatalf("ReadRice failed, got %d", v)
+	}
+	if _, err := r.ReadBit(); err != io.EOF {
+		t.Fatalf("ReadBit failed to detect end, got %v", err)
+	}
+	if err := r.Seek(68); err != nil {
+		t.Fatalf("Seek failed %v", err)
+	}
+	if v, _ := r.ReadUnary(); v != 0 {
+		t.Fatalf("Seek failed, got %d", v)
+	}
+	if r.Seek(114) != ErrRange {
+		t.Fatal("Seek failed to reject position")
+	}
+}
+
+func TestBitStreamGaps(t *testing.T) {
+	ids := []uint64{3, 4, 9, 100, 101, 1000, 1024, 5000}
+	w := NewBitWriter(NewBitset(1))
+	var prev uint64 = 0
+	for _, id := range ids {
+		w.WriteRice(id-prev, 5)
+		w.WriteGamma(id - prev)
+		prev = id
+	}
+	r := NewBitReader(w.Bitset())
+	prev = 0
+	for _, id := range ids {
+		rice, err := r.ReadRice(5)
+		if err != nil {
+			t.Fatalf("ReadRice failed %v", err)
+		}
+		gamma, err := r.ReadGamma()
+		if err != nil || rice != gamma || prev+rice != id {
+			t.Fatalf("decoding gaps failed, expected %d, got %d and %d", id, prev+rice, prev+gamma)
+		}
+		prev = id
+	}
+	// the reader of the whole bitset sees the padding zero bits
+	for r.Remaining() != 0 {
+		if bit, _ := r.ReadBit(); bit {
+			t.Fatal("BitWriter failed, padding bit set")
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package bitset
+
+import (
+	"unsafe"
+)
+
+// bloomBlockBits is the number of bits of a block of a BlockedBloomFilter, a 64 byte cache line
+const bloomBlockBits = 512
+
+// BlockedBloomFilter is a Bloom filter whose k probes for a key all fall within one 64 byte
+// block of its Bitset. The underlying byte array starts on a 64 byte boundary, so on the usual
+// 64 byte cache lines adding or testing a key touches a single cache line instead of k of
+// them. The block is chosen by one half of the hash of the key and the probes within the
+// block by the other half. It needs a little more memory than a BloomFilter for the same false
+// positive rate, as keys are not spread as evenly. It is safe to use from multiple goroutines.
+type BlockedBloomFilter struct {
+	bits   *Bitset
+	blocks uint64
+	k      uint32
+}
+
+// NewBlockedBloomFilter returns an empty blocked Bloom filter of m bits probed k times per
+// key. m is rounded up to a multiple of 512 and k is at least 1
+func NewBlockedBloomFilter(m uint64, k uint32) *BlockedBloomFilter {
+	if m > maxBloomBits {
+		m = maxBloomBits
+	}
+	blocks := (m + bloomBlockBits - 1) / bloomBlockBits
+	if blocks == 0 {
+		blocks = 1
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &BlockedBloomFilter{bits: newBlockAlignedBitset(uint32(blocks * bloomBlockBits >> 3)),
+		blocks: blocks, k: k}
+}
+
+// newBlockAlignedBitset returns a bitset of size bytes whose underlying byte array starts on a
+// 64 byte boundary. Go never moves heap objects, so it stays aligned
+func newBlockAlignedBitset(size uint32) *Bitset {
+	const blockbytes = bloomBlockBits >> 3
+	buf := make([]byte, uint64(size)+blockbytes)
+	off := uint64(-uintptr(unsafe.Pointer(&buf[0])) & (blockbytes - 1))
+	return NewBitsetFromArray(buf[off : off+uint64(size) : off+uint64(size)])
+}
+
+// NewBlockedBloomFilterWithEstimates returns an empty blocked Bloom filter sized to hold n
+// keys with a false positive rate close to p. The size is that of a BloomFilter with the same
+// estimates
+func NewBlockedBloomFilterWithEstimates(n uint64, p float64) *BlockedBloomFilter {
+	m, k := EstimateBloomParameters(n, p)
+	return NewBlockedBloomFilter(m, k)
+}
+
+// Cap returns the number of bits of the filter
+func (bf *BlockedBloomFilter) Cap() uint64 {
+	return bf.blocks * bloomBlockBits
+}
+
+// K returns the number of probes per key
+func (bf *BlockedBloomFilter) K() uint32 {
+	return bf.k
+}
+
+// Add adds key to the filter
+func (bf *BlockedBloomFilter) Add(key []byte) {
+	base, g1, g2 := bf.locate(key)
+	bs := bf.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i := uint32(0); i < bf.k; i++ {
+		bs.setBit(base + (g1+i*g2)%bloomBlockBits)
+	}
+}
+
+// AddString adds key to the filter
+func (bf *BlockedBloomFilter) AddString(key string) {
+	bf.Add([]byte(key))
+}
+
+// Test returns true if key may have been added to the filter, false if it was surely not
+func (bf *BlockedBloomFilter) Test(key []byte) bool {
+	base, g1, g2 := bf.locate(key)
+	bs := bf.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	for i := uint32(0); i < bf.k; i++ {
+		if !bs.isSet(base + (g1+i*g2)%bloomBlockBits) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString returns true if key may have been added to the filter, false if it was surely not
+func (bf *BlockedBloomFilter) TestString(key string) bool {
+	return bf.Test([]byte(key))
+}
+
+// TestAndAdd adds key to the filter and returns what Test would have returned before
+func (bf *BlockedBloomFilter) TestAndAdd(key []byte) bool {
+	base, g1, g2 := bf.locate(key)
+	bs := bf.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	present := true
+	for i := uint32(0); i < bf.k; i++ {
+		position := base + (g1+i*g2)%bloomBlockBits
+		if !bs.isSet(position) {
+			present = false
+			bs.setBit(position)
+		}
+	}
+	return present
+}
+
+// TestAndAddString adds key to the filter and returns what TestString would have returned
+// before
+func (bf *BlockedBloomFilter) TestAndAddString(key string) bool {
+	return bf.TestAndAdd([]byte(key))
+}
+
+// FillRatio returns the fraction of the bits of the filter which are set
+func (bf *BlockedBloomFilter) FillRatio() float64 {
+	return float64(bf.bits.GetSetbitCount()) / float64(bf.Cap())
+}
+
+// ClearAll removes all the keys from the filter
+func (bf *BlockedBloomFilter) ClearAll() {
+	bf.bits.ClearAll()
+}
+
+// Union adds the keys of other to the filter. ErrIncompatible is returned if the filters don't
+// have the same number of blocks and probes
+func (bf *BlockedBloomFilter) Union(other *BlockedBloomFilter) error {
+	if bf.blocks != other.blocks || bf.k != other.k {
+		return ErrIncompatible
+	}
+	if bf != other {
+		bf.bits.Or(other.bits)
+	}
+	return nil
+}
+
+// locate returns the position of the first bit of the block of key and the two hashes from
+// which the probes within the block are derived
+func (bf *BlockedBloomFilter) locate(key []byte) (uint32, uint32, uint32) {
+	h1, h2 := bloomHash(key)
+	base := uint32(h1%bf.blocks) * bloomBlockBits
+	// h2 is odd, so is g2 and the probes are distinct
+	return base, uint32(h2 >> 32), uint32(h2)
+}
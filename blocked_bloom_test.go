@@ -0,0 +1,127 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestBlockedBloomFilter(t *testing.T) {
+	bf := NewBlockedBloomFilterWithEstimates(1000, 0.01)
+	if bf.Cap() != 9728 || bf.K() != 7 {
+		t.Fatalf("NewBlockedBloomFilterWithEstimates failed, got %d and %d", bf.Cap(), bf.K())
+	}
+	for i := 0; i < 1000; i++ {
+		bf.AddString(fmt.Sprintf("key-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !bf.TestString(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("Test failed, false negative for key-%d", i)
+		}
+	}
+	falsepositives := 0
+	for i := 0; i < 10000; i++ {
+		if bf.TestString(fmt.Sprintf("other-%d", i)) {
+			falsepositives++
+		}
+	}
+	if falsepositives > 300 {
+		t.Fatalf("Test failed, %d false positives in 10000", falsepositives)
+	}
+	if bf.FillRatio() < 0.4 || bf.FillRatio() > 0.6 {
+		t.Fatalf("FillRatio failed, got %f", bf.FillRatio())
+	}
+	if !bf.TestAndAddString("key-1") || bf.TestAndAddString("new") || !bf.TestAndAddString("new") {
+		t.Fatal("TestAndAdd failed")
+	}
+
+	other := NewBlockedBloomFilter(bf.Cap(), bf.K())
+	other.AddString("from other")
+	if err := bf.Union(other); err != nil || !bf.TestString("from other") {
+		t.Fatalf("Union failed %v", err)
+	}
+	count := bf.bits.GetSetbitCount()
+	if bf.Union(bf) != nil || bf.bits.GetSetbitCount() != count {
+		t.Fatal("Union failed with the filter itself")
+	}
+	if bf.Union(NewBlockedBloomFilter(512, 7)) != ErrIncompatible {
+		t.Fatal("Union failed to reject incompatible filter")
+	}
+	bf.ClearAll()
+	if bf.TestString("key-1") {
+		t.Fatal("ClearAll failed")
+	}
+	for _, m := range []uint64{512, 1536, 9728, 1 << 20} {
+		buf := NewBlockedBloomFilter(m, 3).bits.buf
+		if uintptr(unsafe.Pointer(&buf[0]))&63 != 0 || uint64(len(buf)) != m>>3 {
+			t.Fatalf("NewBlockedBloomFilter failed to align the blocks of %d bits", m)
+		}
+	}
+}
+
+// the filters hold benchBloomKeys keys in 40MB, more than the last level caches, and every
+// iteration uses a new key, so that the cache lines touched are rarely cached already
+const benchBloomKeys = 1 << 25
+
+// the filters tested are filled once with benchBloomKeys keys, so that a test reads all the
+// probes of its key
+var (
+	benchBloomOnce    sync.Once
+	benchBloomFilter  *BloomFilter
+	benchBlockedOnce  sync.Once
+	benchBlockedBloom *BlockedBloomFilter
+)
+
+// benchKey writes the i-th of a stream of distinct keys to key
+func benchKey(key []byte, i int) []byte {
+	binary.LittleEndian.PutUint64(key, uint64(i))
+	return key
+}
+
+func BenchmarkBloomFilterAdd(b *testing.B) {
+	bf := NewBloomFilterWithEstimates(benchBloomKeys, 0.01)
+	key := make([]byte, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bf.Add(benchKey(key, i))
+	}
+}
+
+func BenchmarkBlockedBloomFilterAdd(b *testing.B) {
+	bf := NewBlockedBloomFilterWithEstimates(benchBloomKeys, 0.01)
+	key := make([]byte, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bf.Add(benchKey(key, i))
+	}
+}
+
+func BenchmarkBloomFilterTest(b *testing.B) {
+	key := make([]byte, 8)
+	benchBloomOnce.Do(func() {
+		benchBloomFilter = NewBloomFilterWithEstimates(benchBloomKeys, 0.01)
+		for i := 0; i < benchBloomKeys; i++ {
+			benchBloomFilter.Add(benchKey(key, i))
+		}
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchBloomFilter.Test(benchKey(key, i%benchBloomKeys))
+	}
+}
+
+func BenchmarkBlockedBloomFilterTest(b *testing.B) {
+	key := make([]byte, 8)
+	benchBlockedOnce.Do(func() {
+		benchBlockedBloom = NewBlockedBloomFilterWithEstimates(benchBloomKeys, 0.01)
+		for i := 0; i < benchBloomKeys; i++ {
+			benchBlockedBloom.Add(benchKey(key, i))
+		}
+	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchBlockedBloom.Test(benchKey(key, i%benchBloomKeys))
+	}
+}
@@ -0,0 +1,236 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// maxBloomBits is the largest number of bits of a filter, as positions in a Bitset are uint32
+const maxBloomBits = 1 << 32
+
+var bloomMagic = []byte("BLM1")
+
+// BloomFilter is a Bloom filter backed by a Bitset. It answers whether a key may have been
+// added, with false positives at a rate depending on its size and fill but never with false
+// negatives. The k probe positions of a key are derived from a 128 bit FNV-1a hash of the key
+// by double hashing. It is safe to use from multiple goroutines, except UnmarshalBinary.
+type BloomFilter struct {
+	bits *Bitset
+	m    uint64
+	k    uint32
+}
+
+// NewBloomFilter returns an empty Bloom filter of m bits probed k times per key. m is rounded
+// up to a multiple of 8 and k is at least 1
+func NewBloomFilter(m uint64, k uint32) *BloomFilter {
+	if m == 0 {
+		m = 8
+	}
+	if m > maxBloomBits {
+		m = maxBloomBits
+	}
+	if k == 0 {
+		k = 1
+	}
+	size := uint32((m + 7) >> 3)
+	return &BloomFilter{bits: NewBitset(size), m: uint64(size) << 3, k: k}
+}
+
+// NewBloomFilterWithEstimates returns an empty Bloom filter sized to hold n keys with a false
+// positive rate of p
+func NewBloomFilterWithEstimates(n uint64, p float64) *BloomFilter {
+	m, k := EstimateBloomParameters(n, p)
+	return NewBloomFilter(m, k)
+}
+
+// EstimateBloomParameters returns the number of bits m and the number of probes k of a Bloom
+// filter holding n keys with a false positive rate of p
+func EstimateBloomParameters(n uint64, p float64) (uint64, uint32) {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Ceil(m / float64(n) * math.Ln2)
+	return uint64(m), uint32(k)
+}
+
+// Cap returns the number of bits of the filter
+func (bf *BloomFilter) Cap() uint64 {
+	return bf.m
+}
+
+// K returns the number of probes per key
+func (bf *BloomFilter) K() uint32 {
+	return bf.k
+}
+
+// Add adds key to the filter
+func (bf *BloomFilter) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	bs := bf.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i := uint32(0); i < bf.k; i++ {
+		bs.setBit(bloomLocation(h1, h2, i, bf.m))
+	}
+}
+
+// AddString adds key to the filter
+func (bf *BloomFilter) AddString(key string) {
+	bf.Add([]byte(key))
+}
+
+// Test returns true if key may have been added to the filter, false if it was surely not
+func (bf *BloomFilter) Test(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	bs := bf.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	for i := uint32(0); i < bf.k; i++ {
+		if !bs.isSet(bloomLocation(h1, h2, i, bf.m)) {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString returns true if key may have been added to the filter, false if it was surely not
+func (bf *BloomFilter) TestString(key string) bool {
+	return bf.Test([]byte(key))
+}
+
+// TestAndAdd adds key to the filter and returns what Test would have returned before. Both
+// happen under a single lock acquisition, so of many goroutines adding the same new key only
+// one gets false
+func (bf *BloomFilter) TestAndAdd(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	bs := bf.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	present := true
+	for i := uint32(0); i < bf.k; i++ {
+		position := bloomLocation(h1, h2, i, bf.m)
+		if !bs.isSet(position) {
+			present = false
+			bs.setBit(position)
+		}
+	}
+	return present
+}
+
+// TestAndAddString adds key to the filter and returns what TestString would have returned
+// before
+func (bf *BloomFilter) TestAndAddString(key string) bool {
+	return bf.TestAndAdd([]byte(key))
+}
+
+// FillRatio returns the fraction of the bits of the filter which are set
+func (bf *BloomFilter) FillRatio() float64 {
+	return float64(bf.bits.GetSetbitCount()) / float64(bf.m)
+}
+
+// EstimatedCount returns an estimate of the number of distinct keys added, derived from the
+// fill ratio
+func (bf *BloomFilter) EstimatedCount() uint64 {
+	fill := bf.FillRatio()
+	if fill >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(-float64(bf.m) / float64(bf.k) * math.Log(1-fill))
+}
+
+// FalsePositiveRate returns the current probability of Test returning true for a key that was
+// not added
+func (bf *BloomFilter) FalsePositiveRate() float64 {
+	return math.Pow(bf.FillRatio(), float64(bf.k))
+}
+
+// ClearAll removes all the keys from the filter
+func (bf *BloomFilter) ClearAll() {
+	bf.bits.ClearAll()
+}
+
+// Union adds the keys of other to the filter. ErrIncompatible is returned if the filters don't
+// have the same number of bits and probes
+func (bf *BloomFilter) Union(other *BloomFilter) error {
+	if bf.m != other.m || bf.k != other.k {
+		return ErrIncompatible
+	}
+	if bf != other {
+		bf.bits.Or(other.bits)
+	}
+	return nil
+}
+
+// Intersect keeps in the filter only the bits also set in other, approximating the
+// intersection of the key sets. ErrIncompatible is returned if the filters don't have the same
+// number of bits and probes
+func (bf *BloomFilter) Intersect(other *BloomFilter) error {
+	if bf.m != other.m || bf.k != other.k {
+		return ErrIncompatible
+	}
+	if bf != other {
+		bf.bits.And(other.bits)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the filter, implementing encoding.BinaryMarshaler
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	buf := bf.bits.GetBytes()
+	data := make([]byte, 12, 12+len(buf))
+	copy(data, bloomMagic)
+	binary.LittleEndian.PutUint32(data[4:], bf.k)
+	binary.LittleEndian.PutUint32(data[8:], uint32(len(buf)))
+	return append(data, buf...), nil
+}
+
+// UnmarshalBinary replaces the filter with the one encoded by MarshalBinary, implementing
+// encoding.BinaryUnmarshaler. ErrFileFormat is returned if data is not an encoded filter. It
+// replaces the bitset and the parameters of the filter without locking, so it must not run
+// while other goroutines use the filter
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 || string(data[:4]) != string(bloomMagic) {
+		return ErrFileFormat
+	}
+	k := binary.LittleEndian.Uint32(data[4:])
+	size := binary.LittleEndian.Uint32(data[8:])
+	if k == 0 || size == 0 || uint64(len(data)-12) != uint64(size) {
+		return ErrFileFormat
+	}
+	bits := NewBitset(size)
+	copy(bits.buf, data[12:])
+	bf.bits = bits
+	bf.m = uint64(size) << 3
+	bf.k = k
+	return nil
+}
+
+// bloomLocation returns the i-th of m probe positions for the key hashed to h1 and h2
+func bloomLocation(h1 uint64, h2 uint64, i uint32, m uint64) uint32 {
+	return uint32((h1 + uint64(i)*h2) % m)
+}
+
+// bloomHash returns the two halves of the 128 bit FNV-1a hash of key
+func bloomHash(key []byte) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write(key)
+	sum := h.Sum(nil)
+	// keys differing only in their last bytes get FNV hashes differing only in a few bits, so
+	// both halves are mixed. An odd second hash keeps the probes distinct when m is a power of 2
+	return bloomMix(binary.BigEndian.Uint64(sum[:8])), bloomMix(binary.BigEndian.Uint64(sum[8:])) | 1
+}
+
+// bloomMix is the finalizer of SplitMix64, spreading every bit of h over all the bits
+func bloomMix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,99 @@
+package bitset
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	m, k := EstimateBloomParameters(1000, 0.01)
+	if m != 9586 || k != 7 {
+		t.Fatalf("EstimateBloomParameters failed, got %d and %d", m, k)
+	}
+	bf := NewBloomFilterWithEstimates(1000, 0.01)
+	if bf.Cap() != 9592 || bf.K() != 7 {
+		t.Fatalf("NewBloomFilterWithEstimates failed, got %d and %d", bf.Cap(), bf.K())
+	}
+	for i := 0; i < 1000; i++ {
+		bf.AddString(fmt.Sprintf("key-%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !bf.TestString(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("Test failed, false negative for key-%d", i)
+		}
+	}
+	falsepositives := 0
+	for i := 0; i < 10000; i++ {
+		if bf.TestString(fmt.Sprintf("other-%d", i)) {
+			falsepositives++
+		}
+	}
+	if falsepositives > 200 {
+		t.Fatalf("Test failed, %d false positives in 10000", falsepositives)
+	}
+	if count := bf.EstimatedCount(); count < 950 || count > 1050 {
+		t.Fatalf("EstimatedCount failed, got %d", count)
+	}
+	if rate := bf.FalsePositiveRate(); rate > 0.02 {
+		t.Fatalf("FalsePositiveRate failed, got %f", rate)
+	}
+	if math.Abs(bf.FillRatio()-0.5) > 0.05 {
+		t.Fatalf("FillRatio failed, got %f", bf.FillRatio())
+	}
+
+	if !bf.TestAndAddString("key-1") {
+		t.Fatal("TestAndAdd failed for present key")
+	}
+	if bf.TestAndAddString("new") || !bf.TestAndAddString("new") {
+		t.Fatal("TestAndAdd failed for new key")
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed %v", err)
+	}
+	other := &BloomFilter{}
+	if err = other.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed %v", err)
+	}
+	if other.Cap() != bf.Cap() || other.K() != bf.K() || !other.TestString("key-5") {
+		t.Fatal("UnmarshalBinary failed")
+	}
+	if err = other.UnmarshalBinary(data[:20]); err != ErrFileFormat {
+		t.Fatal("UnmarshalBinary failed to detect truncated data")
+	}
+}
+
+func TestBloomFilterSetOps(t *testing.T) {
+	a := NewBloomFilter(1024, 3)
+	b := NewBloomFilter(1024, 3)
+	a.AddString("a")
+	a.AddString("both")
+	b.AddString("b")
+	b.AddString("both")
+	union := NewBloomFilter(1024, 3)
+	union.Union(a)
+	union.Union(b)
+	if !union.TestString("a") || !union.TestString("b") || !union.TestString("both") {
+		t.Fatal("Union failed")
+	}
+	if err := a.Intersect(b); err != nil {
+		t.Fatalf("Intersect failed %v", err)
+	}
+	if !a.TestString("both") || a.TestString("a") {
+		t.Fatal("Intersect failed")
+	}
+	// a filter combined with itself is unchanged
+	count := a.bits.GetSetbitCount()
+	if a.Union(a) != nil || a.Intersect(a) != nil || a.bits.GetSetbitCount() != count {
+		t.Fatal("Union or Intersect failed with the filter itself")
+	}
+	if a.Union(NewBloomFilter(2048, 3)) != ErrIncompatible {
+		t.Fatal("Union failed to detect incompatible filters")
+	}
+	a.ClearAll()
+	if a.TestString("both") {
+		t.Fatal("ClearAll failed")
+	}
+}
@@ -0,0 +1,304 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+)
+
+var (
+	linearCounterMagic = []byte("LCT1")
+	mrBitmapMagic      = []byte("MRB1")
+)
+
+// mrBitmapMaxFill is the fill ratio beyond which a component of a MultiResolutionBitmap is
+// too loaded for an accurate linear count and is left out of the estimate
+const mrBitmapMaxFill = 0.7
+
+// LinearCounter estimates the number of distinct keys added to it by linear counting. Every
+// key sets one bit of a Bitset of m bits chosen by its hash, and the count is estimated as
+// -m ln(z/m) from the number z of zero bits. It is accurate up to a few times m keys, beyond
+// which it saturates. It is safe to use from multiple goroutines, except UnmarshalBinary.
+type LinearCounter struct {
+	bits *Bitset
+	m    uint64
+}
+
+// NewLinearCounter returns an empty linear counter of m bits, rounded up to a multiple of 8
+func NewLinearCounter(m uint64) *LinearCounter {
+	if m == 0 {
+		m = 8
+	}
+	if m > maxBloomBits {
+		m = maxBloomBits
+	}
+	size := uint32((m + 7) >> 3)
+	return &LinearCounter{bits: NewBitset(size), m: uint64(size) << 3}
+}
+
+// Cap returns the number of bits of the counter
+func (lc *LinearCounter) Cap() uint64 {
+	return lc.m
+}
+
+// Add adds key to the counter
+func (lc *LinearCounter) Add(key []byte) {
+	h1, _ := bloomHash(key)
+	bs := lc.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.setBit(uint32(h1 % lc.m))
+}
+
+// AddString adds key to the counter
+func (lc *LinearCounter) AddString(key string) {
+	lc.Add([]byte(key))
+}
+
+// Estimate returns the estimated number of distinct keys added. ErrFull is returned with an
+// estimate of m ln m if all the bits are set, as the count can then be arbitrarily large
+func (lc *LinearCounter) Estimate() (uint64, error) {
+	n, err := linearCount(lc.m, lc.bits.GetZerobitCount())
+	return uint64(math.Round(n)), err
+}
+
+// StandardError returns the standard error of Estimate relative to the estimated count,
+// sqrt(m(e^t-t-1))/n with t = n/m
+func (lc *LinearCounter) StandardError() float64 {
+	n, _ := linearCount(lc.m, lc.bits.GetZerobitCount())
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(linearCountVariance(lc.m, n)) / n
+}
+
+// Merge adds the keys of other to the counter, so that it estimates the number of distinct
+// keys added to any of them. ErrIncompatible is returned if the counters don't have the same
+// number of bits
+func (lc *LinearCounter) Merge(other *LinearCounter) error {
+	if lc.m != other.m {
+		return ErrIncompatible
+	}
+	if lc != other {
+		lc.bits.Or(other.bits)
+	}
+	return nil
+}
+
+// Reset removes all the keys from the counter
+func (lc *LinearCounter) Reset() {
+	lc.bits.ClearAll()
+}
+
+// MarshalBinary encodes the counter, implementing encoding.BinaryMarshaler
+func (lc *LinearCounter) MarshalBinary() ([]byte, error) {
+	buf := lc.bits.GetBytes()
+	data := make([]byte, 8, 8+len(buf))
+	copy(data, linearCounterMagic)
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(buf)))
+	return append(data, buf...), nil
+}
+
+// UnmarshalBinary replaces the counter with the one encoded by MarshalBinary, implementing
+// encoding.BinaryUnmarshaler. ErrFileFormat is returned if data is not an encoded counter. It
+// replaces the bitset and the size of the counter without locking, so it must not run while
+// other goroutines use the counter
+func (lc *LinearCounter) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 || string(data[:4]) != string(linearCounterMagic) {
+		return ErrFileFormat
+	}
+	size := binary.LittleEndian.Uint32(data[4:])
+	if size == 0 || uint64(len(data)-8) != uint64(size) {
+		return ErrFileFormat
+	}
+	bs := NewBitset(size)
+	copy(bs.buf, data[8:])
+	lc.bits = bs
+	lc.m = uint64(size) << 3
+	return nil
+}
+
+// MultiResolutionBitmap estimates the number of distinct keys added to it over a much wider
+// range than a LinearCounter of the same size. It is made of c components of b bits stored in
+// one Bitset. A key is hashed into component i with probability 2^-(i+1), the last component
+// taking the remaining 2^-(c-1), and sets one bit of it. The estimate skips the first
+// components while they are too full and scales up the linear counts of the others by the
+// fraction of the keys they sample. It is safe to use from multiple goroutines, except
+// UnmarshalBinary.
+type MultiResolutionBitmap struct {
+	bits *Bitset
+	c    uint32
+	b    uint32
+}
+
+// NewMultiResolutionBitmap returns an empty multi-resolution bitmap of c components of b bits
+// each. c is between 2 and 64 and b is rounded up to a multiple of 8. ErrRange is returned if
+// c*b exceeds the 2^32 bit positions of a Bitset
+func NewMultiResolutionBitmap(c uint32, b uint32) (*MultiResolutionBitmap, error) {
+	if c < 2 {
+		c = 2
+	}
+	if c > 64 {
+		c = 64
+	}
+	if b == 0 {
+		b = 8
+	}
+	size := (uint64(b) + 7) >> 3
+	if size*uint64(c)<<3 > maxBloomBits {
+		return nil, ErrRange
+	}
+	return &MultiResolutionBitmap{bits: NewBitset(uint32(size) * c), c: c, b: uint32(size << 3)},
+		nil
+}
+
+// Components returns the number of components of the bitmap
+func (mb *MultiResolutionBitmap) Components() uint32 {
+	return mb.c
+}
+
+// ComponentBits returns the number of bits of a component of the bitmap
+func (mb *MultiResolutionBitmap) ComponentBits() uint32 {
+	return mb.b
+}
+
+// Add adds key to the bitmap
+func (mb *MultiResolutionBitmap) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	// the lowest bit of h2 is always set
+	level := uint32(bits.TrailingZeros64(h2 >> 1))
+	if level > mb.c-1 {
+		level = mb.c - 1
+	}
+	bs := mb.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.setBit(level*mb.b + uint32(h1%uint64(mb.b)))
+}
+
+// AddString adds key to the bitmap
+func (mb *MultiResolutionBitmap) AddString(key string) {
+	mb.Add([]byte(key))
+}
+
+// Estimate returns the estimated number of distinct keys added. ErrFull is returned with the
+// largest estimate the bitmap can give if even its last component is too full
+func (mb *MultiResolutionBitmap) Estimate() (uint64, error) {
+	n, _, err := mb.estimate()
+	return uint64(math.Round(n)), err
+}
+
+// StandardError returns the standard error of Estimate relative to the estimated count. It
+// combines the errors of the linear counts of the components used with the error of sampling
+// the keys into them
+func (mb *MultiResolutionBitmap) StandardError() float64 {
+	n, variance, _ := mb.estimate()
+	if n == 0 {
+		return 0
+	}
+	return math.Sqrt(variance) / n
+}
+
+// Merge adds the keys of other to the bitmap, so that it estimates the number of distinct keys
+// added to any of them. ErrIncompatible is returned if the bitmaps don't have the same number
+// and size of components
+func (mb *MultiResolutionBitmap) Merge(other *MultiResolutionBitmap) error {
+	if mb.c != other.c || mb.b != other.b {
+		return ErrIncompatible
+	}
+	if mb != other {
+		mb.bits.Or(other.bits)
+	}
+	return nil
+}
+
+// Reset removes all the keys from the bitmap
+func (mb *MultiResolutionBitmap) Reset() {
+	mb.bits.ClearAll()
+}
+
+// MarshalBinary encodes the bitmap, implementing encoding.BinaryMarshaler
+func (mb *MultiResolutionBitmap) MarshalBinary() ([]byte, error) {
+	buf := mb.bits.GetBytes()
+	data := make([]byte, 12, 12+len(buf))
+	copy(data, mrBitmapMagic)
+	binary.LittleEndian.PutUint32(data[4:], mb.c)
+	binary.LittleEndian.PutUint32(data[8:], mb.b)
+	return append(data, buf...), nil
+}
+
+// UnmarshalBinary replaces the bitmap with the one encoded by MarshalBinary, implementing
+// encoding.BinaryUnmarshaler. ErrFileFormat is returned if data is not an encoded bitmap. It
+// replaces the bitset and the dimensions of the bitmap without locking, so it must not run
+// while other goroutines use the bitmap
+func (mb *MultiResolutionBitmap) UnmarshalBinary(data []byte) error {
+	if len(data) < 12 || string(data[:4]) != string(mrBitmapMagic) {
+		return ErrFileFormat
+	}
+	c := binary.LittleEndian.Uint32(data[4:])
+	b := binary.LittleEndian.Uint32(data[8:])
+	if c < 2 || c > 64 || b == 0 || b&7 != 0 || uint64(c)*uint64(b) > maxBloomBits ||
+		uint64(len(data)-12) != uint64(c)*uint64(b>>3) {
+		return ErrFileFormat
+	}
+	bs := NewBitset(c * (b >> 3))
+	copy(bs.buf, data[12:])
+	mb.bits = bs
+	mb.c = c
+	mb.b = b
+	return nil
+}
+
+// estimate returns the estimated count and its variance
+func (mb *MultiResolutionBitmap) estimate() (float64, float64, error) {
+	zeros := mb.zeros()
+	base := uint32(0)
+	for base < mb.c-1 && float64(zeros[base]) < (1-mrBitmapMaxFill)*float64(mb.b) {
+		base++
+	}
+	var err error = nil
+	sum, variance := 0.0, 0.0
+	for i := base; i < mb.c; i++ {
+		n, lerr := linearCount(uint64(mb.b), zeros[i])
+		if lerr != nil {
+			err = lerr
+		}
+		sum += n
+		variance += linearCountVariance(uint64(mb.b), n)
+	}
+	scale := math.Ldexp(1, int(base))
+	n := sum * scale
+	// the linear counts are scaled up and the keys are sampled with probability 1/scale
+	return n, variance*scale*scale + n*(scale-1), err
+}
+
+// zeros returns the number of zero bits of every component
+func (mb *MultiResolutionBitmap) zeros() []uint64 {
+	bs := mb.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	ret := make([]uint64, mb.c)
+	size := mb.b >> 3
+	for i := uint32(0); i < mb.c; i++ {
+		for _, b := range bs.buf[i*size : (i+1)*size] {
+			ret[i] += uint64(zerobits[b])
+		}
+	}
+	return ret
+}
+
+// linearCount returns the linear counting estimate -m ln(z/m) for z zero bits out of m.
+// ErrFull is returned with the estimate for a single zero bit if z is 0
+func linearCount(m uint64, z uint64) (float64, error) {
+	if z == 0 {
+		return float64(m) * math.Log(float64(m)), ErrFull
+	}
+	return -float64(m) * math.Log(float64(z)/float64(m)), nil
+}
+
+// linearCountVariance returns the variance m(e^t-t-1) of a linear count of n keys in m bits,
+// with t = n/m
+func linearCountVariance(m uint64, n float64) float64 {
+	t := n / float64(m)
+	return float64(m) * (math.Exp(t) - t - 1)
+}
@@ -0,0 +1,112 @@
+package bitset
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestLinearCounter(t *testing.T) {
+	lc := NewLinearCounter(10000)
+	if n, err := lc.Estimate(); n != 0 || err != nil {
+		t.Fatalf("Estimate failed for empty counter, got %d %v", n, err)
+	}
+	for i := 0; i < 5000; i++ {
+		lc.AddString(fmt.Sprintf("key-%d", i))
+		lc.AddString(fmt.Sprintf("key-%d", i/2))
+	}
+	n, err := lc.Estimate()
+	if err != nil || math.Abs(float64(n)-5000) > 5000*4*lc.StandardError() {
+		t.Fatalf("Estimate failed, got %d %v", n, err)
+	}
+	if e := lc.StandardError(); e <= 0 || e > 0.02 {
+		t.Fatalf("StandardError failed, got %f", e)
+	}
+
+	other := NewLinearCounter(10000)
+	for i := 2500; i < 7500; i++ {
+		other.AddString(fmt.Sprintf("key-%d", i))
+	}
+	if err = lc.Merge(other); err != nil {
+		t.Fatalf("Merge failed %v", err)
+	}
+	if n, _ = lc.Estimate(); math.Abs(float64(n)-7500) > 7500*4*lc.StandardError() {
+		t.Fatalf("Merge failed, estimated %d", n)
+	}
+	if lc.Merge(NewLinearCounter(80)) != ErrIncompatible {
+		t.Fatal("Merge failed to reject incompatible counter")
+	}
+
+	data, _ := lc.MarshalBinary()
+	copied := &LinearCounter{}
+	if err = copied.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed %v", err)
+	}
+	if m, _ := copied.Estimate(); m != n || copied.Cap() != lc.Cap() {
+		t.Fatalf("UnmarshalBinary failed, estimated %d", m)
+	}
+	if copied.UnmarshalBinary(data[:len(data)-1]) != ErrFileFormat {
+		t.Fatal("UnmarshalBinary failed to reject truncated data")
+	}
+
+	small := NewLinearCounter(8)
+	for i := 0; i < 1000; i++ {
+		small.AddString(fmt.Sprintf("key-%d", i))
+	}
+	if _, err = small.Estimate(); err != ErrFull {
+		t.Fatalf("Estimate failed to report saturation, got %v", err)
+	}
+	small.Reset()
+	if n, _ = small.Estimate(); n != 0 {
+		t.Fatal("Reset failed")
+	}
+}
+
+func TestMultiResolutionBitmap(t *testing.T) {
+	mb, err := NewMultiResolutionBitmap(16, 1000)
+	if err != nil || mb.Components() != 16 || mb.ComponentBits() != 1000 {
+		t.Fatalf("NewMultiResolutionBitmap failed %v", err)
+	}
+	if _, err = NewMultiResolutionBitmap(64, 1<<27); err != ErrRange {
+		t.Fatal("NewMultiResolutionBitmap failed to reject oversized bitmap")
+	}
+	// far beyond what a linear counter of the same 16000 bits could count
+	for _, count := range []int{100, 10000, 300000} {
+		mb.Reset()
+		for i := 0; i < count; i++ {
+			mb.AddString(fmt.Sprintf("key-%d", i))
+		}
+		n, err := mb.Estimate()
+		e := mb.StandardError()
+		if err != nil || e <= 0 || e > 0.1 {
+			t.Fatalf("Estimate failed for %d keys, got %d %f %v", count, n, e, err)
+		}
+		if math.Abs(float64(n)-float64(count)) > float64(count)*4*e {
+			t.Fatalf("Estimate failed for %d keys, got %d", count, n)
+		}
+	}
+
+	other, _ := NewMultiResolutionBitmap(16, 1000)
+	for i := 300000; i < 400000; i++ {
+		other.AddString(fmt.Sprintf("key-%d", i))
+	}
+	if err = mb.Merge(other); err != nil {
+		t.Fatalf("Merge failed %v", err)
+	}
+	n, _ := mb.Estimate()
+	if math.Abs(float64(n)-400000) > 400000*4*mb.StandardError() {
+		t.Fatalf("Merge failed, estimated %d", n)
+	}
+
+	data, _ := mb.MarshalBinary()
+	copied := &MultiResolutionBitmap{}
+	if err = copied.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed %v", err)
+	}
+	if m, _ := copied.Estimate(); m != n {
+		t.Fatalf("UnmarshalBinary failed, estimated %d", m)
+	}
+	if copied.UnmarshalBinary(data[:11]) != ErrFileFormat {
+		t.Fatal("UnmarshalBinary failed to reject truncated data")
+	}
+}
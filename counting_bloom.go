@@ -0,0 +1,151 @@
+package bitset
+
+// CountingBloomFilter is a Bloom filter whose positions are small counters instead of bits, so
+// that keys can be removed. The counters are packed in a Bitset, 4 or 8 bits each, and read
+// and written the way GetVal and SetVal do. A counter that reaches its maximum value sticks
+// there, as it can no longer tell how many keys it counts. It hashes keys exactly as
+// BloomFilter does. It is safe to use from multiple goroutines.
+type CountingBloomFilter struct {
+	counters *Bitset
+	m        uint64
+	k        uint32
+	width    uint32
+	max      uint32
+}
+
+// NewCountingBloomFilter returns an empty counting Bloom filter of m counters of width bits,
+// probed k times per key. m is rounded up to a multiple of 8 and k is at least 1. It returns
+// nil if width is neither 4 nor 8
+func NewCountingBloomFilter(m uint64, k uint32, width uint32) *CountingBloomFilter {
+	if width != 4 && width != 8 {
+		return nil
+	}
+	if m == 0 {
+		m = 8
+	}
+	if m > maxBloomBits/uint64(width) {
+		m = maxBloomBits / uint64(width)
+	}
+	if k == 0 {
+		k = 1
+	}
+	m = (m + 7) &^ 7
+	return &CountingBloomFilter{counters: NewBitset(uint32(m * uint64(width) >> 3)), m: m, k: k,
+		width: width, max: ones32[width-1]}
+}
+
+// NewCountingBloomFilterWithEstimates returns an empty counting Bloom filter of counters of
+// width bits sized to hold n keys with a false positive rate of p. It returns nil if width is
+// neither 4 nor 8
+func NewCountingBloomFilterWithEstimates(n uint64, p float64, width uint32) *CountingBloomFilter {
+	m, k := EstimateBloomParameters(n, p)
+	return NewCountingBloomFilter(m, k, width)
+}
+
+// Cap returns the number of counters of the filter
+func (cf *CountingBloomFilter) Cap() uint64 {
+	return cf.m
+}
+
+// K returns the number of probes per key
+func (cf *CountingBloomFilter) K() uint32 {
+	return cf.k
+}
+
+// Add adds key to the filter, incrementing its counters unless they are saturated
+func (cf *CountingBloomFilter) Add(key []byte) {
+	h1, h2 := bloomHash(key)
+	bs := cf.counters
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i := uint32(0); i < cf.k; i++ {
+		start, end := cf.counter(bloomLocation(h1, h2, i, cf.m))
+		if c := bs.getVal(start, end); c < cf.max {
+			bs.setVal(start, end, c+1)
+		}
+	}
+}
+
+// AddString adds key to the filter
+func (cf *CountingBloomFilter) AddString(key string) {
+	cf.Add([]byte(key))
+}
+
+// Remove removes key from the filter, decrementing its counters unless they are saturated. It
+// returns false and leaves the filter untouched if key is surely not in the filter. Removing a
+// key that was never added can introduce false negatives for other keys
+func (cf *CountingBloomFilter) Remove(key []byte) bool {
+	h1, h2 := bloomHash(key)
+	bs := cf.counters
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i := uint32(0); i < cf.k; i++ {
+		if bs.getVal(cf.counter(bloomLocation(h1, h2, i, cf.m))) == 0 {
+			return false
+		}
+	}
+	for i := uint32(0); i < cf.k; i++ {
+		start, end := cf.counter(bloomLocation(h1, h2, i, cf.m))
+		if c := bs.getVal(start, end); c < cf.max {
+			bs.setVal(start, end, c-1)
+		}
+	}
+	return true
+}
+
+// RemoveString removes key from the filter
+func (cf *CountingBloomFilter) RemoveString(key string) bool {
+	return cf.Remove([]byte(key))
+}
+
+// Test returns true if key may be in the filter, false if it surely is not
+func (cf *CountingBloomFilter) Test(key []byte) bool {
+	return cf.Count(key) != 0
+}
+
+// TestString returns true if key may be in the filter, false if it surely is not
+func (cf *CountingBloomFilter) TestString(key string) bool {
+	return cf.Test([]byte(key))
+}
+
+// Count returns the smallest counter of key, an upper bound of the number of times key was
+// added and not removed as long as no counter is saturated
+func (cf *CountingBloomFilter) Count(key []byte) uint32 {
+	h1, h2 := bloomHash(key)
+	bs := cf.counters
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	least := cf.max
+	for i := uint32(0); i < cf.k; i++ {
+		if c := bs.getVal(cf.counter(bloomLocation(h1, h2, i, cf.m))); c < least {
+			least = c
+		}
+	}
+	return least
+}
+
+// ClearAll removes all the keys from the filter
+func (cf *CountingBloomFilter) ClearAll() {
+	cf.counters.ClearAll()
+}
+
+// ToBloomFilter returns a plain Bloom filter with a bit set for every non-zero counter. It
+// answers Test exactly as the counting filter did when it was converted
+func (cf *CountingBloomFilter) ToBloomFilter() *BloomFilter {
+	bf := NewBloomFilter(cf.m, cf.k)
+	bs := cf.counters
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	for i := uint64(0); i < cf.m; i++ {
+		if bs.getVal(cf.counter(uint32(i))) != 0 {
+			bf.bits.SetBit(uint32(i))
+		}
+	}
+	return bf
+}
+
+// counter returns the first and last bit positions of the counter at index
+func (cf *CountingBloomFilter) counter(index uint32) (uint32, uint32) {
+	start := index * cf.width
+	return start, start + cf.width - 1
+}
@@ -0,0 +1,84 @@
+package bitset
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountingBloomFilter(t *testing.T) {
+	if NewCountingBloomFilter(100, 3, 5) != nil {
+		t.Fatal("NewCountingBloomFilter failed to reject width 5")
+	}
+	for _, width := range []uint32{4, 8} {
+		cf := NewCountingBloomFilterWithEstimates(500, 0.01, width)
+		for i := 0; i < 500; i++ {
+			cf.AddString(fmt.Sprintf("key-%d", i))
+		}
+		for i := 0; i < 500; i++ {
+			if !cf.TestString(fmt.Sprintf("key-%d", i)) {
+				t.Fatalf("Test failed, false negative for key-%d", i)
+			}
+		}
+		for i := 0; i < 250; i++ {
+			if !cf.RemoveString(fmt.Sprintf("key-%d", i)) {
+				t.Fatalf("Remove failed for key-%d", i)
+			}
+		}
+		present := 0
+		for i := 0; i < 250; i++ {
+			if cf.TestString(fmt.Sprintf("key-%d", i)) {
+				present++
+			}
+		}
+		if present > 10 {
+			t.Fatalf("Remove failed, %d removed keys still present", present)
+		}
+		for i := 250; i < 500; i++ {
+			if !cf.TestString(fmt.Sprintf("key-%d", i)) {
+				t.Fatalf("Remove failed, false negative for key-%d", i)
+			}
+		}
+		if cf.RemoveString("never added") {
+			t.Fatal("Remove failed, removed a key never added")
+		}
+
+		bf := cf.ToBloomFilter()
+		if bf.Cap() != cf.Cap() || bf.K() != cf.K() {
+			t.Fatal("ToBloomFilter failed")
+		}
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			if bf.TestString(key) != cf.TestString(key) {
+				t.Fatalf("ToBloomFilter failed, answers differ for %s", key)
+			}
+		}
+		cf.ClearAll()
+		if cf.TestString("key-300") {
+			t.Fatal("ClearAll failed")
+		}
+	}
+}
+
+func TestCountingBloomFilterSaturation(t *testing.T) {
+	cf := NewCountingBloomFilter(64, 2, 4)
+	for i := 0; i < 20; i++ {
+		cf.AddString("hot")
+	}
+	if c := cf.Count([]byte("hot")); c != 15 {
+		t.Fatalf("Add failed to saturate, expected 15, got %d", c)
+	}
+	for i := 0; i < 20; i++ {
+		cf.RemoveString("hot")
+	}
+	if !cf.TestString("hot") {
+		t.Fatal("Remove failed, saturated counter decremented")
+	}
+	cf = NewCountingBloomFilter(64, 2, 8)
+	for i := 0; i < 3; i++ {
+		cf.AddString("warm")
+	}
+	cf.RemoveString("warm")
+	if c := cf.Count([]byte("warm")); c != 2 {
+		t.Fatalf("Count failed, expected 2, got %d", c)
+	}
+}
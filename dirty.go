@@ -0,0 +1,119 @@
+package bitset
+
+// DefaultDirtyPageSize is the page size in bytes used by EnableDirtyTracking when 0 is passed
+const DefaultDirtyPageSize = 4096
+
+// ByteRange is the range of bytes Start <= offset < End of the underlying byte array
+type ByteRange struct {
+	Start uint32
+	End   uint32
+}
+
+// DirtyRegion holds a copy of the dirty bytes of the underlying byte array starting at Offset
+type DirtyRegion struct {
+	Offset uint32
+	Data   []byte
+}
+
+// dirtyPages is a coarse bitmap with one bit for every page of the underlying byte array, set
+// when a byte of the page was modified
+type dirtyPages struct {
+	pagesize uint32
+	pages    *Bitset
+}
+
+// EnableDirtyTracking makes every modification of the bitset mark the pages of pagesize bytes
+// it touched as dirty, so that only the dirty pages need to be written out. All pages start
+// clean. If tracking is already enabled, the dirty state is discarded and tracking restarts
+// with the new page size. Modifications made through the slice returned by GetBytesUnsafe are
+// not tracked
+func (bs *Bitset) EnableDirtyTracking(pagesize uint32) {
+	if pagesize == 0 {
+		pagesize = DefaultDirtyPageSize
+	}
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.dirty = &dirtyPages{pagesize: pagesize, pages: NewBitset(0)}
+	bs.dirty.resize(bs.size, bs.size)
+}
+
+// DisableDirtyTracking stops tracking the modified pages and discards the dirty state
+func (bs *Bitset) DisableDirtyTracking() {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.dirty = nil
+}
+
+// DirtyRanges returns the byte ranges of the underlying byte array modified since tracking was
+// enabled or TakeDirty was last called, with adjacent dirty pages coalesced into one range. It
+// returns nil if dirty tracking is disabled
+func (bs *Bitset) DirtyRanges() []ByteRange {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.dirtyRanges()
+}
+
+// TakeDirty returns a copy of the contents of the dirty ranges and marks all the pages clean,
+// in a single lock acquisition so that no modification is lost between the two. It returns nil
+// if dirty tracking is disabled
+func (bs *Bitset) TakeDirty() []DirtyRegion {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	ranges := bs.dirtyRanges()
+	if ranges == nil {
+		return nil
+	}
+	regions := make([]DirtyRegion, len(ranges))
+	for i, r := range ranges {
+		data := make([]byte, r.End-r.Start)
+		copy(data, bs.buf[r.Start:r.End])
+		regions[i] = DirtyRegion{Offset: r.Start, Data: data}
+	}
+	bs.dirty.pages.ClearAll()
+	return regions
+}
+
+// dirtyRanges does the work of DirtyRanges, the caller must hold the lock
+func (bs *Bitset) dirtyRanges() []ByteRange {
+	if bs.dirty == nil {
+		return nil
+	}
+	pages := bs.dirty.pages
+	pagesize := uint64(bs.dirty.pagesize)
+	npages := (uint64(bs.size) + pagesize - 1) / pagesize
+	ranges := []ByteRange{}
+	var pos int64 = 0
+	for uint64(pos) < npages {
+		start := pages.findRun(uint32(pos), 1, true)
+		if start < 0 || uint64(start) >= npages {
+			break
+		}
+		end := pages.findRun(uint32(start), 1, false)
+		if end < 0 || uint64(end) > npages {
+			end = int64(npages)
+		}
+		last := uint64(end) * pagesize
+		if last > uint64(bs.size) {
+			last = uint64(bs.size)
+		}
+		ranges = append(ranges, ByteRange{Start: uint32(uint64(start) * pagesize), End: uint32(last)})
+		pos = end
+	}
+	return ranges
+}
+
+// mark marks the pages holding the bytes startbyte <= byte <= endbyte dirty
+func (d *dirtyPages) mark(startbyte uint32, endbyte uint32) {
+	d.pages.setRange(startbyte/d.pagesize, endbyte/d.pagesize)
+}
+
+// resize adjusts the bitmap for a byte array growing or shrinking from oldsize to newsize
+// bytes. The bytes added by growing are marked dirty
+func (d *dirtyPages) resize(oldsize uint32, newsize uint32) {
+	pagesize := uint64(d.pagesize)
+	npages := (uint64(newsize) + pagesize - 1) / pagesize
+	d.pages.resize(uint32((npages + 7) >> 3))
+	if newsize > oldsize {
+		d.mark(oldsize, newsize-1)
+	}
+}
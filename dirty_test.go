@@ -0,0 +1,66 @@
+package bitset
+
+import (
+	"testing"
+)
+
+func TestDirtyTracking(t *testing.T) {
+	bs := NewBitset(100)
+	bs.SetBit(5)
+	if bs.DirtyRanges() != nil {
+		t.Fatal("DirtyRanges failed, expected nil while tracking is disabled")
+	}
+	bs.EnableDirtyTracking(10)
+	if len(bs.DirtyRanges()) != 0 {
+		t.Fatal("EnableDirtyTracking failed, expected all pages clean")
+	}
+	bs.SetBit(5)
+	bs.SetVal(90, 100, 7)
+	bs.ClearRange(8*35, 8*52)
+	ranges := bs.DirtyRanges()
+	expected := []ByteRange{{0, 20}, {30, 60}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("DirtyRanges failed, expected %v, got %v", expected, ranges)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Fatalf("DirtyRanges failed, expected %v, got %v", expected, ranges)
+		}
+	}
+
+	regions := bs.TakeDirty()
+	if len(regions) != 2 || regions[0].Offset != 0 || len(regions[0].Data) != 20 ||
+		regions[1].Offset != 30 || len(regions[1].Data) != 30 {
+		t.Fatalf("TakeDirty failed, got %v", regions)
+	}
+	if regions[0].Data[0] != 4 {
+		t.Fatalf("TakeDirty failed, expected first byte 4, got %d", regions[0].Data[0])
+	}
+	if len(bs.DirtyRanges()) != 0 {
+		t.Fatal("TakeDirty failed to mark the pages clean")
+	}
+
+	other := NewBitset(25)
+	bs.Xor(other)
+	ranges = bs.DirtyRanges()
+	if len(ranges) != 1 || ranges[0] != (ByteRange{0, 30}) {
+		t.Fatalf("Xor failed to mark pages dirty, got %v", ranges)
+	}
+	bs.TakeDirty()
+	bs.Resize(105)
+	bs.SetBit(0)
+	ranges = bs.DirtyRanges()
+	if len(ranges) != 2 || ranges[0] != (ByteRange{0, 10}) || ranges[1] != (ByteRange{100, 105}) {
+		t.Fatalf("Resize failed to mark pages dirty, got %v", ranges)
+	}
+	bs.TakeDirty()
+	bs.ClearAll()
+	ranges = bs.DirtyRanges()
+	if len(ranges) != 1 || ranges[0] != (ByteRange{0, 105}) {
+		t.Fatalf("ClearAll failed to mark pages dirty, got %v", ranges)
+	}
+	bs.DisableDirtyTracking()
+	if bs.TakeDirty() != nil {
+		t.Fatal("DisableDirtyTracking failed")
+	}
+}
@@ -0,0 +1,389 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	walSetRange byte = iota + 1
+	walClearRange
+	walResize
+)
+
+const (
+	// walHeaderSize is the size of the log header: the generation of the checkpoint the
+	// records of the log follow
+	walHeaderSize = 8
+	// walRecordSize is the size of a log record: the operation, two uint32 arguments and the
+	// crc32 of the preceding bytes
+	walRecordSize = 13
+	// checkpointHeaderSize is the size of the checkpoint header: checkpointMagic, the
+	// generation of the checkpoint, the size of the bitset in bytes and the crc32 of the bytes
+	// of the bitset
+	checkpointHeaderSize = 24
+
+	// DefaultCheckpointInterval is the number of logged operations after which a DurableBitset
+	// writes a checkpoint, unless changed with SetCheckpointInterval
+	DefaultCheckpointInterval = 10000
+
+	walFileName        = "bitset.wal"
+	checkpointFileName = "bitset.checkpoint"
+)
+
+var checkpointMagic = []byte("BSCKPT02")
+
+// DurableBitset is a Bitset whose modifications survive crashes. Every modification is
+// appended to a write-ahead log and flushed to the disk before it is applied. Periodically the
+// whole bitset is written to a checkpoint file, atomically by writing a temporary file and
+// renaming it, and the log is reset. Every checkpoint has a new generation, recorded at the
+// start of the log it resets, so that opening the bitset after a crash between the two ignores
+// the records of the older log. Opening the bitset loads the checkpoint and replays the log,
+// ignoring a torn record at its end. A record that failed to be written is removed from the
+// log, and if that fails too every modification returns the error until Checkpoint succeeds.
+// A failed automatic checkpoint doesn't fail the modification that triggered it, which is
+// already durable, its error is returned by Close unless a later checkpoint succeeds.
+type DurableBitset struct {
+	mutex    sync.Mutex
+	bs       *Bitset
+	dir      string
+	wal      walFile
+	gen      uint64
+	logged   uint32
+	interval uint32
+	// err is the error that left the log in an unknown state, the modifications are refused
+	// until a checkpoint resets it
+	err error
+	// ckerr is the error of the last automatic checkpoint if it failed
+	ckerr error
+}
+
+// walFile is the file of the log, an *os.File except in tests injecting errors
+type walFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// OpenDurableBitset opens the durable bitset stored in directory dir, creating the directory
+// and an empty bitset of size bytes if there is none. size is ignored when the bitset exists.
+// ErrFileFormat is returned if the checkpoint is corrupted
+func OpenDurableBitset(dir string, size uint32) (*DurableBitset, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	bs, gen, err := loadCheckpoint(filepath.Join(dir, checkpointFileName))
+	created := os.IsNotExist(err)
+	if created {
+		bs, gen, err = NewBitset(size), 0, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d := &DurableBitset{bs: bs, dir: dir, wal: wal, gen: gen, interval: DefaultCheckpointInterval}
+	if created {
+		// the initial checkpoint records the size of the new bitset
+		err = d.checkpoint()
+	} else {
+		err = d.replay()
+	}
+	if err != nil {
+		wal.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// SetCheckpointInterval sets the number of logged operations after which a checkpoint is
+// written. 0 disables automatic checkpoints
+func (d *DurableBitset) SetCheckpointInterval(interval uint32) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.interval = interval
+}
+
+// SetBit durably sets the bit at position. Non-nil error is returned if the position is out of
+// range or the log could not be written, in which case the bit is not modified
+func (d *DurableBitset) SetBit(position uint32) error {
+	return d.apply(walSetRange, position, position)
+}
+
+// ResetBit durably resets the bit at position. Non-nil error is returned if the position is
+// out of range or the log could not be written, in which case the bit is not modified
+func (d *DurableBitset) ResetBit(position uint32) error {
+	return d.apply(walClearRange, position, position)
+}
+
+// SetRange durably sets the bits in positions start <= position <= end. Non-nil error is
+// returned if any of the positions is out of range or the log could not be written
+func (d *DurableBitset) SetRange(start uint32, end uint32) error {
+	return d.apply(walSetRange, start, end)
+}
+
+// ClearRange durably resets the bits in positions start <= position <= end. Non-nil error is
+// returned if any of the positions is out of range or the log could not be written
+func (d *DurableBitset) ClearRange(start uint32, end uint32) error {
+	return d.apply(walClearRange, start, end)
+}
+
+// Resize durably expands or contracts the bitset keeping the content intact for the copied
+// bytes. Non-nil error is returned if the log could not be written
+func (d *DurableBitset) Resize(newsize uint32) error {
+	return d.apply(walResize, newsize, 0)
+}
+
+// IsSet returns true if the bit is set at position, false otherwise. error retuned will be
+// non-nil if the position exceeds the bitset capacity, nil otherwise
+func (d *DurableBitset) IsSet(position uint32) (bool, error) {
+	return d.bs.IsSet(position)
+}
+
+// GetSize returns the size of the bitset in bytes
+func (d *DurableBitset) GetSize() uint32 {
+	return d.bs.GetSize()
+}
+
+// GetSetbitCount returns the number of set or 1 bits in the bitset
+func (d *DurableBitset) GetSetbitCount() uint64 {
+	return d.bs.GetSetbitCount()
+}
+
+// Freeze returns an immutable copy of the bitset for the other read operations
+func (d *DurableBitset) Freeze() *FrozenBitset {
+	return d.bs.Freeze()
+}
+
+// Checkpoint writes the whole bitset to the checkpoint file and resets the log. It makes the
+// bitset accept modifications again after a failure to remove a torn record from the log
+func (d *DurableBitset) Checkpoint() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.checkpoint()
+}
+
+// Close flushes and closes the log. It also returns the error of a failed automatic checkpoint
+// if no checkpoint succeeded since. The bitset must not be modified afterwards
+func (d *DurableBitset) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	err := d.wal.Sync()
+	if cerr := d.wal.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = d.ckerr
+	}
+	return err
+}
+
+// apply validates, logs and applies one operation
+func (d *DurableBitset) apply(op byte, arg1 uint32, arg2 uint32) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	if op != walResize {
+		if arg1 > arg2 {
+			arg1, arg2 = arg2, arg1
+		}
+		// only this goroutine modifies the bitset, so the size can't change before applying
+		if arg2>>3 >= d.bs.GetSize() {
+			return ErrRange
+		}
+	}
+	record := make([]byte, walRecordSize)
+	record[0] = op
+	binary.LittleEndian.PutUint32(record[1:], arg1)
+	binary.LittleEndian.PutUint32(record[5:], arg2)
+	binary.LittleEndian.PutUint32(record[9:], crc32.ChecksumIEEE(record[:9]))
+	offset, err := d.wal.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = d.wal.Write(record); err == nil {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		d.rollback(offset)
+		return err
+	}
+	d.redo(op, arg1, arg2)
+	d.logged++
+	if d.interval != 0 && d.logged >= d.interval {
+		// the operation is durable whatever happens to the checkpoint
+		if err = d.checkpoint(); err != nil {
+			d.ckerr = err
+		}
+	}
+	return nil
+}
+
+// rollback removes the record that failed to be written at offset from the log. Left there, a
+// torn record would stop the replay before the records acknowledged after it. If the log can't
+// be truncated, further modifications are refused
+func (d *DurableBitset) rollback(offset int64) {
+	err := d.wal.Truncate(offset)
+	if err == nil {
+		_, err = d.wal.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		err = d.wal.Sync()
+	}
+	if err != nil {
+		d.err = err
+	}
+}
+
+// redo applies one logged operation to the bitset
+func (d *DurableBitset) redo(op byte, arg1 uint32, arg2 uint32) {
+	switch op {
+	case walSetRange:
+		d.bs.SetRange(arg1, arg2)
+	case walClearRange:
+		d.bs.ClearRange(arg1, arg2)
+	case walResize:
+		d.bs.Resize(arg1)
+	}
+}
+
+// replay applies the records of the log up to the first torn or corrupted one, and truncates
+// the log after the last good record so that new records are appended to it. A log of an older
+// generation than the checkpoint, left by a crash before a checkpoint reset it, is reset
+func (d *DurableBitset) replay() error {
+	if _, err := d.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(d.wal)
+	if err != nil {
+		return err
+	}
+	if len(data) < walHeaderSize || binary.LittleEndian.Uint64(data) != d.gen {
+		return d.resetLog()
+	}
+	good := walHeaderSize
+	for good+walRecordSize <= len(data) {
+		record := data[good : good+walRecordSize]
+		if binary.LittleEndian.Uint32(record[9:]) != crc32.ChecksumIEEE(record[:9]) {
+			break
+		}
+		op := record[0]
+		arg1 := binary.LittleEndian.Uint32(record[1:])
+		arg2 := binary.LittleEndian.Uint32(record[5:])
+		if op != walSetRange && op != walClearRange && op != walResize {
+			break
+		}
+		if op != walResize && arg2>>3 >= d.bs.GetSize() {
+			break
+		}
+		d.redo(op, arg1, arg2)
+		d.logged++
+		good += walRecordSize
+	}
+	if good != len(data) {
+		if err = d.wal.Truncate(int64(good)); err != nil {
+			return err
+		}
+		if err = d.wal.Sync(); err != nil {
+			return err
+		}
+	}
+	_, err = d.wal.Seek(int64(good), io.SeekStart)
+	return err
+}
+
+// checkpoint does the work of Checkpoint, the caller must hold the lock
+func (d *DurableBitset) checkpoint() error {
+	buf := d.bs.GetBytes()
+	gen := d.gen + 1
+	header := make([]byte, checkpointHeaderSize)
+	copy(header, checkpointMagic)
+	binary.LittleEndian.PutUint64(header[8:], gen)
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(header[20:], crc32.ChecksumIEEE(buf))
+
+	path := filepath.Join(d.dir, checkpointFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(header)
+	if err == nil {
+		_, err = tmp.Write(buf)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	if dir, err := os.Open(d.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	d.gen = gen
+	// records appended to the log before it is reset would be ignored as those of an older
+	// generation, so the modifications are refused until a checkpoint succeeds
+	if err = d.resetLog(); err != nil {
+		d.err = err
+		return err
+	}
+	d.logged = 0
+	d.err = nil
+	d.ckerr = nil
+	return nil
+}
+
+// resetLog empties the log and starts it with the generation of the checkpoint
+func (d *DurableBitset) resetLog() error {
+	if err := d.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := d.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := make([]byte, walHeaderSize)
+	binary.LittleEndian.PutUint64(header, d.gen)
+	if _, err := d.wal.Write(header); err != nil {
+		return err
+	}
+	return d.wal.Sync()
+}
+
+// loadCheckpoint reads the bitset and the generation stored in the checkpoint file at path
+func loadCheckpoint(path string) (*Bitset, uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) < checkpointHeaderSize ||
+		string(data[:len(checkpointMagic)]) != string(checkpointMagic) {
+		return nil, 0, ErrFileFormat
+	}
+	gen := binary.LittleEndian.Uint64(data[8:])
+	size := binary.LittleEndian.Uint32(data[16:])
+	buf := data[checkpointHeaderSize:]
+	if uint64(len(buf)) != uint64(size) ||
+		binary.LittleEndian.Uint32(data[20:]) != crc32.ChecksumIEEE(buf) {
+		return nil, 0, ErrFileFormat
+	}
+	bs := NewBitset(size)
+	copy(bs.buf, buf)
+	return bs, gen, nil
+}
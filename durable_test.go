@@ -0,0 +1,250 @@
+package bitset
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDurableBitset(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "durable")
+	d, err := OpenDurableBitset(dir, 10)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed %v", err)
+	}
+	d.SetBit(3)
+	d.SetRange(10, 29)
+	d.ClearRange(15, 19)
+	d.ResetBit(10)
+	if err = d.SetBit(80); err != ErrRange {
+		t.Fatal("SetBit failed to detect invalid position")
+	}
+	d.Resize(20)
+	d.SetBit(150)
+	// crash without checkpoint or close
+	d.wal.Close()
+
+	d, err = OpenDurableBitset(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed to replay %v", err)
+	}
+	if d.GetSize() != 20 || d.GetSetbitCount() != 16 {
+		t.Fatalf("replay failed, got size %d and %d set bits", d.GetSize(), d.GetSetbitCount())
+	}
+	if ret, _ := d.IsSet(15); ret {
+		t.Fatal("replay failed, bit 15 is set")
+	}
+
+	if err = d.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed %v", err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, walFileName)); info.Size() != walHeaderSize {
+		t.Fatal("Checkpoint failed to reset the log")
+	}
+	d.SetBit(0)
+	d.Close()
+
+	d, err = OpenDurableBitset(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed to load checkpoint %v", err)
+	}
+	if d.GetSize() != 20 || d.GetSetbitCount() != 17 {
+		t.Fatalf("checkpoint failed, got size %d and %d set bits", d.GetSize(), d.GetSetbitCount())
+	}
+	// the replayed SetBit(0) counts towards the interval
+	d.SetCheckpointInterval(3)
+	d.SetBit(1)
+	d.SetBit(2)
+	if info, _ := os.Stat(filepath.Join(dir, walFileName)); info.Size() != walHeaderSize {
+		t.Fatal("automatic checkpoint failed")
+	}
+	d.Close()
+}
+
+func TestDurableBitsetTornWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "durable")
+	d, err := OpenDurableBitset(dir, 4)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed %v", err)
+	}
+	d.SetBit(1)
+	d.SetBit(2)
+	d.Close()
+
+	// a crash in the middle of appending the third record
+	path := filepath.Join(dir, walFileName)
+	wal, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	wal.Write([]byte{walSetRange, 5, 0, 0, 0, 5, 0})
+	wal.Close()
+	d, err = OpenDurableBitset(dir, 4)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed with torn record %v", err)
+	}
+	if d.GetSetbitCount() != 2 {
+		t.Fatalf("replay failed, expected 2 set bits, got %d", d.GetSetbitCount())
+	}
+	if info, _ := os.Stat(path); info.Size() != walHeaderSize+2*walRecordSize {
+		t.Fatalf("replay failed to truncate the torn record, log size %d", info.Size())
+	}
+	d.SetBit(7)
+	d.Close()
+
+	// a record whose bytes did not all reach the disk
+	data, _ := os.ReadFile(path)
+	data[walHeaderSize+walRecordSize+1] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	d, err = OpenDurableBitset(dir, 4)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed with corrupted record %v", err)
+	}
+	if ret, _ := d.IsSet(1); !ret || d.GetSetbitCount() != 1 {
+		t.Fatalf("replay failed, expected only bit 1 set, got %d set bits", d.GetSetbitCount())
+	}
+	d.Checkpoint()
+	d.Close()
+
+	// a leftover temporary checkpoint is ignored, a corrupted checkpoint is detected
+	os.WriteFile(filepath.Join(dir, checkpointFileName+".tmp"), []byte("garbage"), 0644)
+	if d, err = OpenDurableBitset(dir, 4); err != nil {
+		t.Fatalf("OpenDurableBitset failed with leftover temporary file %v", err)
+	}
+	d.Close()
+	path = filepath.Join(dir, checkpointFileName)
+	data, _ = os.ReadFile(path)
+	data[checkpointHeaderSize] ^= 0xff
+	os.WriteFile(path, data, 0644)
+	if _, err = OpenDurableBitset(dir, 4); err != ErrFileFormat {
+		t.Fatalf("OpenDurableBitset failed to detect corrupted checkpoint, got %v", err)
+	}
+}
+
+// faultyWAL fails the next write after writing part of the record, and the truncation of the
+// log if failtruncate is set
+type faultyWAL struct {
+	walFile
+	failwrite    bool
+	failtruncate bool
+}
+
+var errInjected = errors.New("injected error")
+
+func (f *faultyWAL) Write(p []byte) (int, error) {
+	if f.failwrite {
+		f.failwrite = false
+		n, _ := f.walFile.Write(p[:5])
+		return n, errInjected
+	}
+	return f.walFile.Write(p)
+}
+
+func (f *faultyWAL) Truncate(size int64) error {
+	if f.failtruncate {
+		f.failtruncate = false
+		return errInjected
+	}
+	return f.walFile.Truncate(size)
+}
+
+func TestDurableBitsetWriteError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "durable")
+	d, err := OpenDurableBitset(dir, 4)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed %v", err)
+	}
+	d.SetBit(1)
+	wal := &faultyWAL{walFile: d.wal, failwrite: true}
+	d.wal = wal
+	if err = d.SetBit(2); err != errInjected {
+		t.Fatalf("SetBit failed to return the write error, got %v", err)
+	}
+	if err = d.SetBit(3); err != nil {
+		t.Fatalf("SetBit failed after a write error %v", err)
+	}
+	// crash without checkpoint or close
+	wal.Close()
+
+	d, err = OpenDurableBitset(dir, 4)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed to replay %v", err)
+	}
+	bit2, _ := d.IsSet(2)
+	bit3, _ := d.IsSet(3)
+	if bit2 || !bit3 || d.GetSetbitCount() != 2 {
+		t.Fatalf("replay failed to keep the records after the failed one, got %d set bits",
+			d.GetSetbitCount())
+	}
+
+	// the log can't be cleaned up, so the modifications are refused until a checkpoint
+	d.wal = &faultyWAL{walFile: d.wal, failwrite: true, failtruncate: true}
+	d.SetBit(4)
+	if err = d.SetBit(5); err != errInjected {
+		t.Fatalf("SetBit failed to refuse modifications, got %v", err)
+	}
+	if ret, _ := d.IsSet(5); ret {
+		t.Fatal("SetBit failed, refused modification applied")
+	}
+	if err = d.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed %v", err)
+	}
+	if err = d.SetBit(5); err != nil {
+		t.Fatalf("SetBit failed after Checkpoint %v", err)
+	}
+	d.Close()
+	d, _ = OpenDurableBitset(dir, 4)
+	defer d.Close()
+	if ret, _ := d.IsSet(5); !ret || d.GetSetbitCount() != 3 {
+		t.Fatalf("replay failed, got %d set bits", d.GetSetbitCount())
+	}
+}
+
+func TestDurableBitsetCheckpointCrash(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "durable")
+	d, err := OpenDurableBitset(dir, 10)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed %v", err)
+	}
+	d.ClearRange(0, 0)
+	d.SetBit(70)
+	d.Resize(5)
+	d.SetBit(0)
+	path := filepath.Join(dir, walFileName)
+	stale, _ := os.ReadFile(path)
+	if err = d.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed %v", err)
+	}
+	d.Close()
+
+	// a crash after the new checkpoint was renamed into place but before the log was reset
+	os.WriteFile(path, stale, 0644)
+	d, err = OpenDurableBitset(dir, 1)
+	if err != nil {
+		t.Fatalf("OpenDurableBitset failed %v", err)
+	}
+	if ret, _ := d.IsSet(0); !ret || d.GetSize() != 5 || d.GetSetbitCount() != 1 {
+		t.Fatalf("replay failed to skip the stale log, got size %d and %d set bits", d.GetSize(),
+			d.GetSetbitCount())
+	}
+	d.SetBit(1)
+	d.Close()
+	d, _ = OpenDurableBitset(dir, 1)
+	if ret, _ := d.IsSet(1); !ret || d.GetSetbitCount() != 2 {
+		t.Fatal("replay failed after resetting the stale log")
+	}
+
+	// a failed automatic checkpoint doesn't fail the logged modification, Close reports it
+	os.Mkdir(filepath.Join(dir, checkpointFileName+".tmp"), 0755)
+	d.SetCheckpointInterval(1)
+	if err = d.SetBit(2); err != nil {
+		t.Fatalf("SetBit failed with the error of the checkpoint %v", err)
+	}
+	if err = d.Close(); err == nil {
+		t.Fatal("Close failed to return the error of the automatic checkpoint")
+	}
+	os.Remove(filepath.Join(dir, checkpointFileName+".tmp"))
+	d, _ = OpenDurableBitset(dir, 1)
+	defer d.Close()
+	if ret, _ := d.IsSet(2); !ret || d.GetSetbitCount() != 3 {
+		t.Fatal("replay failed to restore the modification of the failed checkpoint")
+	}
+}
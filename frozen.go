@@ -0,0 +1,194 @@
+package bitset
+
+import (
+	"io"
+)
+
+// frozenChunkSize is the largest copy of the bytes of a FrozenBitset WriteTo hands to a writer
+const frozenChunkSize = 32 << 10
+
+// FrozenBitset is an immutable bitset. It only has read methods, and as its contents never
+// change they run without any locking. It is safe to hand out to code that must not modify
+// the bits, as no method gives access to the underlying byte array.
+type FrozenBitset struct {
+	bits *Bitset
+}
+
+// Freeze returns an immutable copy of the bitset, with its bits in the same order
+func (bs *Bitset) Freeze() *FrozenBitset {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	fs := NewFrozenBitset(bs.buf)
+	fs.bits.order = bs.order
+	return fs
+}
+
+// NewFrozenBitset returns an immutable bitset holding a copy of the passed slice, the inverse
+// of MarshalBinary
+func NewFrozenBitset(input []byte) *FrozenBitset {
+	buf := make([]byte, len(input))
+	copy(buf, input)
+	// the inner bitset is never modified, so it is used without its mutex
+	return &FrozenBitset{bits: &Bitset{size: uint32(len(buf)), buf: buf}}
+}
+
+// Thaw returns a mutable Bitset holding a copy of the bits, in the same order
+func (fs *FrozenBitset) Thaw() *Bitset {
+	bs := NewBitsetWithOrder(fs.bits.size, fs.bits.order)
+	copy(bs.buf, fs.bits.buf)
+	return bs
+}
+
+// Order returns the order in which the bits of the bitset are numbered within each byte
+func (fs *FrozenBitset) Order() BitOrder {
+	return fs.bits.order
+}
+
+// GetSize returns the size of the bitset in bytes
+func (fs *FrozenBitset) GetSize() uint32 {
+	return fs.bits.size
+}
+
+// IsSet returns true if the bit is set at position, false otherwise. error retuned will be
+// non-nil if the position exceeds the bitset capacity, nil otherwise
+func (fs *FrozenBitset) IsSet(position uint32) (bool, error) {
+	bytepos, bitpos, err := fs.bits.getBitBytePosition(position)
+	if err != nil {
+		return false, err
+	}
+	return fs.bits.buf[bytepos]&ones[bitpos] != 0, nil
+}
+
+// GetByte returns byte that contains bit corresponding to the position.
+// Non-nil error is returned in case the position is out of range
+func (fs *FrozenBitset) GetByte(position uint32) (byte, error) {
+	bytepos, _, err := fs.bits.getBitBytePosition(position)
+	if err != nil {
+		return byte(0), err
+	}
+	return fs.bits.buf[bytepos], nil
+}
+
+// GetBytes returns a copy of the underlying byte array
+func (fs *FrozenBitset) GetBytes() []byte {
+	ret := make([]byte, fs.bits.size)
+	copy(ret, fs.bits.buf)
+	return ret
+}
+
+// GetSetbitCount returns the number of set or 1 bits in the bitset
+func (fs *FrozenBitset) GetSetbitCount() uint64 {
+	return fs.bits.setbitc()
+}
+
+// GetZerobitCount returns the number of 0 bits in the bitset
+func (fs *FrozenBitset) GetZerobitCount() uint64 {
+	return (uint64(fs.bits.size) << 3) - fs.bits.setbitc()
+}
+
+// IsAllZero returns true if all the bits in the set are zero
+func (fs *FrozenBitset) IsAllZero() bool {
+	for _, b := range fs.bits.buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsAllSet returns true if all the bits in the set are 1
+func (fs *FrozenBitset) IsAllSet() bool {
+	for _, b := range fs.bits.buf {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNextSetBit returns position of next set bit after from_position, see Bitset.GetNextSetBit
+func (fs *FrozenBitset) GetNextSetBit(from_position uint32) (int64, error) {
+	return fs.bits.nextSetBit(from_position)
+}
+
+// GetNextZeroBit returns position of next zero bit after from_position, see
+// Bitset.GetNextZeroBit
+func (fs *FrozenBitset) GetNextZeroBit(from_position uint32) (int64, error) {
+	return fs.bits.nextZeroBit(from_position)
+}
+
+// GetPrevSetBit returns position of previous set bit before from_position, see
+// Bitset.GetPrevSetBit
+func (fs *FrozenBitset) GetPrevSetBit(from_position uint32) (int64, error) {
+	return fs.bits.prevSetBit(from_position)
+}
+
+// GetPrevZeroBit returns position of previous zero bit before from_position, see
+// Bitset.GetPrevZeroBit
+func (fs *FrozenBitset) GetPrevZeroBit(from_position uint32) (int64, error) {
+	return fs.bits.prevZeroBit(from_position)
+}
+
+// GetNextZeroRun returns the position of the first run of at least n consecutive zero bits
+// starting at or after from_position, see Bitset.GetNextZeroRun
+func (fs *FrozenBitset) GetNextZeroRun(from_position uint32, n uint32) (int64, error) {
+	if from_position>>3 >= fs.bits.size {
+		return -1, ErrRange
+	}
+	return fs.bits.findRun(from_position, n, false), nil
+}
+
+// GetNextSetRun returns the position of the first run of at least n consecutive set bits
+// starting at or after from_position, see Bitset.GetNextSetRun
+func (fs *FrozenBitset) GetNextSetRun(from_position uint32, n uint32) (int64, error) {
+	if from_position>>3 >= fs.bits.size {
+		return -1, ErrRange
+	}
+	return fs.bits.findRun(from_position, n, true), nil
+}
+
+// ForEachSetBit calls f with the position of every set bit in increasing order, until f
+// returns false
+func (fs *FrozenBitset) ForEachSetBit(f func(position uint32) bool) {
+	for i, b := range fs.bits.buf {
+		b = fs.bits.msb(b)
+		for b != 0 {
+			bitpos := leftm1[b]
+			if !f(uint32(i)<<3 + uint32(7-bitpos)) {
+				return
+			}
+			b &= zeros[bitpos]
+		}
+	}
+}
+
+// MarshalBinary returns a copy of the underlying byte array, implementing
+// encoding.BinaryMarshaler. NewFrozenBitset and NewBitsetFromArrayCopy read it back
+func (fs *FrozenBitset) MarshalBinary() ([]byte, error) {
+	return fs.GetBytes(), nil
+}
+
+// WriteTo writes the underlying byte array to w, implementing io.WriterTo. w is given copies
+// of the bytes, at most frozenChunkSize at a time, so it can't modify the bitset
+func (fs *FrozenBitset) WriteTo(w io.Writer) (int64, error) {
+	buf := fs.bits.buf
+	size := len(buf)
+	if size > frozenChunkSize {
+		size = frozenChunkSize
+	}
+	chunk := make([]byte, size)
+	var written int64 = 0
+	for len(buf) != 0 {
+		n := copy(chunk, buf)
+		m, err := w.Write(chunk[:n])
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		if m != n {
+			return written, io.ErrShortWrite
+		}
+		buf = buf[n:]
+	}
+	return written, nil
+}
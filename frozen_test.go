@@ -0,0 +1,99 @@
+package bitset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFreeze(t *testing.T) {
+	bs := NewBitset(10)
+	bs.SetBit(3)
+	bs.SetRange(20, 29)
+	bs.SetBit(79)
+	fs := bs.Freeze()
+	bs.ClearAll()
+	if fs.GetSize() != 10 || fs.GetSetbitCount() != 12 || fs.GetZerobitCount() != 68 {
+		t.Fatal("Freeze failed, contents changed with the bitset")
+	}
+	if ret, err := fs.IsSet(3); err != nil || !ret {
+		t.Fatal("IsSet failed on frozen bitset")
+	}
+	if _, err := fs.IsSet(80); err != ErrRange {
+		t.Fatal("IsSet failed to detect invalid position")
+	}
+	if indx, err := fs.GetNextSetBit(3); err != nil || indx != 20 {
+		t.Fatalf("GetNextSetBit failed, expected 20, got %d", indx)
+	}
+	if indx, err := fs.GetPrevSetBit(20); err != nil || indx != 3 {
+		t.Fatalf("GetPrevSetBit failed, expected 3, got %d", indx)
+	}
+	if indx, err := fs.GetNextZeroBit(19); err != nil || indx != 30 {
+		t.Fatalf("GetNextZeroBit failed, expected 30, got %d", indx)
+	}
+	if indx, err := fs.GetNextSetRun(0, 5); err != nil || indx != 20 {
+		t.Fatalf("GetNextSetRun failed, expected 20, got %d", indx)
+	}
+	var positions []uint32
+	fs.ForEachSetBit(func(position uint32) bool {
+		positions = append(positions, position)
+		return true
+	})
+	if len(positions) != 12 || positions[0] != 3 || positions[1] != 20 || positions[11] != 79 {
+		t.Fatalf("ForEachSetBit failed, got %v", positions)
+	}
+	count := 0
+	fs.ForEachSetBit(func(position uint32) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatal("ForEachSetBit failed to stop")
+	}
+
+	data, err := fs.MarshalBinary()
+	if err != nil || len(data) != 10 {
+		t.Fatal("MarshalBinary failed")
+	}
+	if !bytes.Equal(NewFrozenBitset(data).GetBytes(), data) {
+		t.Fatal("NewFrozenBitset failed")
+	}
+	thawed := fs.Thaw()
+	thawed.ClearAll()
+	if fs.GetSetbitCount() != 12 {
+		t.Fatal("Thaw failed, frozen bitset modified")
+	}
+	if NewFrozenBitset(nil).Thaw().GetSize() != 0 {
+		t.Fatal("Thaw failed for empty bitset")
+	}
+}
+
+// scribbler is a writer that overwrites what it is given
+type scribbler struct {
+	bytes.Buffer
+}
+
+func (s *scribbler) Write(p []byte) (int, error) {
+	n, err := s.Buffer.Write(p)
+	for i := range p {
+		p[i] = 0xff
+	}
+	return n, err
+}
+
+func TestFrozenWriteTo(t *testing.T) {
+	bs := NewBitset(frozenChunkSize*2 + 10)
+	bs.SetBit(3)
+	bs.SetBit(frozenChunkSize*8 + 5)
+	fs := bs.Freeze()
+	var w scribbler
+	if n, err := fs.WriteTo(&w); err != nil || n != int64(fs.GetSize()) {
+		t.Fatalf("WriteTo failed, wrote %d bytes %v", n, err)
+	}
+	if !bytes.Equal(w.Bytes(), bs.GetBytes()) {
+		t.Fatal("WriteTo failed, wrote wrong contents")
+	}
+	if fs.GetSetbitCount() != 2 {
+		t.Fatalf("WriteTo failed, the writer modified the frozen bitset to %d set bits",
+			fs.GetSetbitCount())
+	}
+}
@@ -0,0 +1,42 @@
+package bitset
+
+// fileMapping is the storage of a Bitset whose underlying byte array lives in a file
+type fileMapping interface {
+	// resize changes the size of the file and returns the new underlying byte array. On error
+	// it returns the underlying byte array to use from now on, which is empty if the file
+	// could not be mapped again
+	resize(newsize uint32) ([]byte, error)
+	sync() error
+	close() error
+}
+
+// Sync flushes the contents of a bitset backed by a file to the disk. It also returns the error
+// of any failed Resize since the previous Sync, and ErrClosed once the file could not be mapped
+// again after a failed Resize. For bitsets not backed by a file it does nothing
+func (bs *Bitset) Sync() error {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if bs.mapping == nil {
+		return nil
+	}
+	return bs.mapping.sync()
+}
+
+// Close flushes the contents of a bitset backed by a file and releases the file. The bitset is
+// empty afterwards. For bitsets not backed by a file it does nothing
+func (bs *Bitset) Close() error {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if bs.mapping == nil {
+		return nil
+	}
+	bs.detachSnapshots()
+	err := bs.mapping.close()
+	bs.mapping = nil
+	bs.size = 0
+	bs.buf = nil
+	if bs.dirty != nil {
+		bs.dirty.resize(0, 0)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+//go:build linux
+
+package bitset
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// A bitset file starts with a header of mmapHeaderSize bytes holding mmapMagic, or
+// mmapMagicLSB if the bits are numbered LSBFirst, followed by the length of the bitset in bits
+// as a little endian uint64, then the bytes of the bitset
+const mmapHeaderSize = 16
+
+var (
+	mmapMagic    = []byte("BITSET01")
+	mmapMagicLSB = []byte("BITSETL1")
+)
+
+// mmapFile is a bitset file mapped in memory. It is broken once the file can't be mapped at
+// all, and then fails every operation with ErrClosed
+type mmapFile struct {
+	file   *os.File
+	data   []byte
+	err    error
+	broken bool
+}
+
+// NewBitsetFromFile returns a bitset operating directly on the memory mapped contents of the
+// file at path, so the bits survive restarts without explicit load and store. If the file
+// does not exist or is empty, it is created with size bytes of zero bits, otherwise size is
+// ignored and the length recorded in the header of the file is used. A new file numbers its bits
+// MSBFirst, an existing one in the order recorded in its header. ErrFileFormat is returned if
+// the file is not a bitset file. Resize grows or shrinks the file, Sync flushes the changes to
+// the disk and Close unmaps the file. It is only supported on Linux
+func NewBitsetFromFile(path string, size uint32) (*Bitset, error) {
+	return openBitsetFile(path, size, MSBFirst, false)
+}
+
+// NewBitsetFromFileWithOrder is NewBitsetFromFile for a bitset whose bits are numbered in order
+// within each byte. The order is recorded in the header of a new file, ErrIncompatible is
+// returned if an existing file records the other order
+func NewBitsetFromFileWithOrder(path string, size uint32, order BitOrder) (*Bitset, error) {
+	return openBitsetFile(path, size, order, true)
+}
+
+// openBitsetFile opens and maps the bitset file at path, creating it with size bytes numbered
+// in order if it is empty. An existing file must record order if check is set
+func openBitsetFile(path string, size uint32, order BitOrder, check bool) (*Bitset, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := mapBitsetFile(file, size, order, check)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+func mapBitsetFile(file *os.File, size uint32, order BitOrder, check bool) (*Bitset, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		header := make([]byte, mmapHeaderSize)
+		if order == LSBFirst {
+			copy(header, mmapMagicLSB)
+		} else {
+			copy(header, mmapMagic)
+		}
+		binary.LittleEndian.PutUint64(header[len(mmapMagic):], uint64(size)<<3)
+		if _, err = file.WriteAt(header, 0); err != nil {
+			return nil, err
+		}
+		if err = file.Truncate(mmapHeaderSize + int64(size)); err != nil {
+			return nil, err
+		}
+	} else {
+		header := make([]byte, mmapHeaderSize)
+		if _, err = file.ReadAt(header, 0); err != nil {
+			return nil, ErrFileFormat
+		}
+		recorded := MSBFirst
+		switch string(header[:len(mmapMagic)]) {
+		case string(mmapMagic):
+		case string(mmapMagicLSB):
+			recorded = LSBFirst
+		default:
+			return nil, ErrFileFormat
+		}
+		if check && recorded != order {
+			return nil, ErrIncompatible
+		}
+		order = recorded
+		nbits := binary.LittleEndian.Uint64(header[len(mmapMagic):])
+		if nbits&7 != 0 || nbits>>3 > 0xffffffff || info.Size() < mmapHeaderSize+int64(nbits>>3) {
+			return nil, ErrFileFormat
+		}
+		size = uint32(nbits >> 3)
+	}
+	m := &mmapFile{file: file}
+	if err = m.mmap(size); err != nil {
+		return nil, err
+	}
+	return &Bitset{size: size, buf: m.data[mmapHeaderSize:], mutex: &sync.RWMutex{}, order: order,
+		mapping: m}, nil
+}
+
+// mmap maps the header and size bytes of the file
+func (m *mmapFile) mmap(size uint32) error {
+	data, err := syscall.Mmap(int(m.file.Fd()), 0, mmapHeaderSize+int(size),
+		syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// resize changes the size of the file. The header never records more bytes than the file
+// holds, even after a crash in the middle: it is flushed with the new size before shrinking
+// the file, and only written after growing it
+func (m *mmapFile) resize(newsize uint32) ([]byte, error) {
+	if m.broken {
+		return nil, ErrClosed
+	}
+	oldsize := uint32(len(m.data) - mmapHeaderSize)
+	if newsize < oldsize {
+		if err := m.setSize(newsize); err != nil {
+			m.err = err
+			m.setSize(oldsize)
+			return m.data[mmapHeaderSize:], err
+		}
+	}
+	if err := syscall.Munmap(m.data); err != nil {
+		m.err = err
+		m.setSize(oldsize)
+		return m.data[mmapHeaderSize:], err
+	}
+	m.data = nil
+	err := m.file.Truncate(mmapHeaderSize + int64(newsize))
+	if err == nil {
+		err = m.mmap(newsize)
+	}
+	if err != nil {
+		m.err = err
+		// map the file back with its old size so that the bitset stays usable. A shrunk file
+		// is first truncated back, the bytes mapped beyond its end could not be accessed
+		if m.file.Truncate(mmapHeaderSize+int64(oldsize)) != nil || m.mmap(oldsize) != nil {
+			m.broken = true
+			return nil, err
+		}
+		m.setSize(oldsize)
+		return m.data[mmapHeaderSize:], err
+	}
+	if newsize > oldsize {
+		if err = m.setSize(newsize); err != nil {
+			// the header holds the new size in memory, only flushing it failed
+			m.err = err
+		}
+	}
+	return m.data[mmapHeaderSize:], err
+}
+
+// setSize records the size of the bitset in the header and flushes the header to the disk
+func (m *mmapFile) setSize(size uint32) error {
+	binary.LittleEndian.PutUint64(m.data[len(mmapMagic):], uint64(size)<<3)
+	return msync(m.data[:mmapHeaderSize])
+}
+
+// msync flushes data, which must start at the beginning of a page, to the disk
+func msync(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])),
+		uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (m *mmapFile) sync() error {
+	if m.broken {
+		return ErrClosed
+	}
+	err := m.err
+	m.err = nil
+	if len(m.data) != 0 {
+		if serr := msync(m.data); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (m *mmapFile) close() error {
+	if m.broken {
+		m.file.Close()
+		return ErrClosed
+	}
+	err := m.sync()
+	if m.data != nil {
+		if uerr := syscall.Munmap(m.data); uerr != nil && err == nil {
+			err = uerr
+		}
+		m.data = nil
+	}
+	if cerr := m.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return err
+}
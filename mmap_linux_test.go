@@ -0,0 +1,134 @@
+//go:build linux
+
+package bitset
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBitsetFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bits")
+	bs, err := NewBitsetFromFile(path, 10)
+	if err != nil {
+		t.Fatalf("NewBitsetFromFile failed %v", err)
+	}
+	if bs.GetSize() != 10 {
+		t.Fatalf("NewBitsetFromFile failed, expected size 10, got %d", bs.GetSize())
+	}
+	bs.SetBit(3)
+	bs.SetRange(40, 79)
+	snap := bs.Snapshot()
+	if err = bs.Sync(); err != nil {
+		t.Fatalf("Sync failed %v", err)
+	}
+	bs.Resize(20)
+	bs.SetBit(150)
+	if info, _ := os.Stat(path); info.Size() != mmapHeaderSize+20 {
+		t.Fatalf("Resize failed to extend the file, size %d", info.Size())
+	}
+	if err = bs.Close(); err != nil {
+		t.Fatalf("Close failed %v", err)
+	}
+	if bs.GetSize() != 0 || bs.SetBit(3) {
+		t.Fatal("Close failed to empty the bitset")
+	}
+	if snap.GetSetbitCount() != 41 {
+		t.Fatalf("Snapshot failed after Close, expected 41 set bits, got %d", snap.GetSetbitCount())
+	}
+
+	bs, err = NewBitsetFromFile(path, 1)
+	if err != nil {
+		t.Fatalf("NewBitsetFromFile failed to reopen %v", err)
+	}
+	defer bs.Close()
+	if bs.GetSize() != 20 || bs.GetSetbitCount() != 42 {
+		t.Fatalf("NewBitsetFromFile failed, got size %d and %d set bits", bs.GetSize(),
+			bs.GetSetbitCount())
+	}
+	if ret, _ := bs.IsSet(150); !ret {
+		t.Fatal("NewBitsetFromFile failed, bit 150 lost")
+	}
+
+	// the header is shrunk before the file, so a crash in between leaves a valid file
+	if err = bs.Resize(5); err != nil {
+		t.Fatalf("Resize failed %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if len(data) != mmapHeaderSize+5 || binary.LittleEndian.Uint64(data[8:]) != 40 {
+		t.Fatalf("Resize failed to shrink the file, got %d bytes", len(data))
+	}
+	crashed := filepath.Join(t.TempDir(), "crashed")
+	os.WriteFile(crashed, append(data, make([]byte, 15)...), 0644)
+	if bs2, err := NewBitsetFromFile(crashed, 1); err != nil || bs2.GetSize() != 5 {
+		t.Fatalf("NewBitsetFromFile failed on a file longer than its header records %v", err)
+	} else {
+		bs2.Close()
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad")
+	os.WriteFile(bad, []byte("definitely not a bitset"), 0644)
+	if _, err = NewBitsetFromFile(bad, 10); err != ErrFileFormat {
+		t.Fatalf("NewBitsetFromFile failed to detect bad file, got %v", err)
+	}
+}
+
+func TestBitsetFromFileResizeError(t *testing.T) {
+	bs, err := NewBitsetFromFile(filepath.Join(t.TempDir(), "bits"), 1)
+	if err != nil {
+		t.Fatalf("NewBitsetFromFile failed %v", err)
+	}
+	alloc := NewIDAllocator(bs, FirstFit)
+	alloc.SetGrowth(1)
+	for i := 0; i < 8; i++ {
+		alloc.Allocate()
+	}
+	// the file can neither grow nor be mapped back once its descriptor is closed
+	bs.mapping.(*mmapFile).file.Close()
+	if _, err = alloc.Allocate(); err == nil {
+		t.Fatal("Allocate failed to return the error of Resize")
+	}
+	if bs.GetSize() != 0 {
+		t.Fatalf("Resize failed to empty the bitset, got size %d", bs.GetSize())
+	}
+	if _, err = alloc.Allocate(); err != ErrClosed {
+		t.Fatalf("Allocate failed to return ErrClosed, got %v", err)
+	}
+	w := NewBitWriter(bs)
+	if err = w.WriteBits(5, 3); err != ErrClosed {
+		t.Fatalf("WriteBits failed to return ErrClosed, got %v", err)
+	}
+	if err = bs.Resize(4); err != ErrClosed || bs.Sync() != ErrClosed || bs.Close() != ErrClosed {
+		t.Fatal("broken mapping failed to return ErrClosed")
+	}
+}
+
+func TestBitsetFromFileOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bits")
+	bs, err := NewBitsetFromFileWithOrder(path, 2, LSBFirst)
+	if err != nil {
+		t.Fatalf("NewBitsetFromFileWithOrder failed %v", err)
+	}
+	bs.SetBit(0)
+	bs.SetBit(9)
+	bs.Close()
+	if data, _ := os.ReadFile(path); string(data[:8]) != "BITSETL1" || data[16] != 0x01 ||
+		data[17] != 0x02 {
+		t.Fatalf("NewBitsetFromFileWithOrder failed to record the order, got %q", data)
+	}
+
+	// the order recorded in the file is used, and a different one is rejected
+	if _, err = NewBitsetFromFileWithOrder(path, 2, MSBFirst); err != ErrIncompatible {
+		t.Fatalf("NewBitsetFromFileWithOrder failed to reject the other order, got %v", err)
+	}
+	bs, err = NewBitsetFromFile(path, 2)
+	if err != nil {
+		t.Fatalf("NewBitsetFromFile failed %v", err)
+	}
+	defer bs.Close()
+	if v, _ := bs.GetVal(0, 9); bs.Order() != LSBFirst || v != 0x201 {
+		t.Fatalf("NewBitsetFromFile failed to use the recorded order, got %x", v)
+	}
+}
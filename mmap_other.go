@@ -0,0 +1,13 @@
+//go:build !linux
+
+package bitset
+
+// NewBitsetFromFile is only supported on Linux, elsewhere it returns ErrNotSupported
+func NewBitsetFromFile(path string, size uint32) (*Bitset, error) {
+	return nil, ErrNotSupported
+}
+
+// NewBitsetFromFileWithOrder is only supported on Linux, elsewhere it returns ErrNotSupported
+func NewBitsetFromFileWithOrder(path string, size uint32, order BitOrder) (*Bitset, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,143 @@
+package bitset
+
+import (
+	"sync"
+)
+
+// ChangeOp tells what kind of modification a ChangeEvent describes
+type ChangeOp uint32
+
+const (
+	// OpSet means the bits in the range were set to 1
+	OpSet ChangeOp = iota
+	// OpReset means the bits in the range were set to 0
+	OpReset
+	// OpFlip means the bits in the range were flipped
+	OpFlip
+	// OpSetVal means the bits in the range were assigned by SetVal
+	OpSetVal
+	// OpAnd means the bits in the range were anded with another bitset
+	OpAnd
+	// OpOr means the bits in the range were ored with another bitset
+	OpOr
+	// OpXor means the bits in the range were xored with another bitset
+	OpXor
+	// OpResize means the bitset was resized to Size bytes, Start and End are 0
+	OpResize
+)
+
+var opchanges = map[uint32]ChangeOp{and: OpAnd, or: OpOr, xor: OpXor}
+
+// ChangeEvent describes one modification of a Bitset. Range operations are reported as a
+// single event covering the bits in positions Start <= position <= End
+type ChangeEvent struct {
+	Op    ChangeOp
+	Start uint32
+	End   uint32
+	// Size is the size of the bitset in bytes after the modification
+	Size uint32
+}
+
+type observer struct {
+	id uint64
+	f  func(ChangeEvent)
+}
+
+// Subscribe registers f to be called for every modification of the bitset and returns an id
+// to pass to Unsubscribe. f is called synchronously with the bitset locked, so it sees the
+// events in order but must not call methods of the bitset and should return quickly.
+// Modifications made through the slice returned by GetBytesUnsafe are not reported
+func (bs *Bitset) Subscribe(f func(ChangeEvent)) uint64 {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.lastobserver++
+	bs.observers = append(bs.observers, observer{id: bs.lastobserver, f: f})
+	return bs.lastobserver
+}
+
+// Unsubscribe stops calling the function registered with Subscribe under id
+func (bs *Bitset) Unsubscribe(id uint64) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i, o := range bs.observers {
+		if o.id == id {
+			bs.observers = append(bs.observers[:i], bs.observers[i+1:]...)
+			return
+		}
+	}
+}
+
+// SubscribeChan returns a channel delivering the events for every modification of the bitset
+// in order, and a function to cancel the subscription which closes the channel. Events are
+// queued without bound, so a slow receiver never blocks the writers of the bitset and may
+// freely call methods of the bitset
+func (bs *Bitset) SubscribeChan() (<-chan ChangeEvent, func()) {
+	out := make(chan ChangeEvent)
+	wake := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	var mutex sync.Mutex
+	var queue []ChangeEvent
+	id := bs.Subscribe(func(ev ChangeEvent) {
+		mutex.Lock()
+		queue = append(queue, ev)
+		mutex.Unlock()
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+	go func() {
+		defer close(out)
+		for {
+			mutex.Lock()
+			pending := queue
+			queue = nil
+			mutex.Unlock()
+			for _, ev := range pending {
+				select {
+				case out <- ev:
+				case <-stop:
+					return
+				}
+			}
+			select {
+			case <-wake:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			bs.Unsubscribe(id)
+			close(stop)
+		})
+	}
+}
+
+// modified reports the modification of the bits in positions start <= position <= end to the
+// observers and wakes up the goroutines waiting for the bitset to change. The caller must hold
+// the write lock
+func (bs *Bitset) modified(op ChangeOp, start uint32, end uint32) {
+	if bs.cond != nil {
+		bs.cond.Broadcast()
+	}
+	if bs.dirty != nil && op != OpResize {
+		bs.dirty.mark(start>>3, end>>3)
+	}
+	if len(bs.observers) != 0 {
+		ev := ChangeEvent{Op: op, Start: start, End: end, Size: bs.size}
+		for _, o := range bs.observers {
+			o.f(ev)
+		}
+	}
+}
+
+// modifiedAll reports a modification of all the bits of the bitset, the caller must hold the
+// write lock
+func (bs *Bitset) modifiedAll(op ChangeOp) {
+	if bs.size != 0 {
+		bs.modified(op, 0, bs.size<<3-1)
+	}
+}
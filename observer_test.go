@@ -0,0 +1,67 @@
+package bitset
+
+import (
+	"testing"
+)
+
+func TestSubscribe(t *testing.T) {
+	bs := NewBitset(4)
+	var events []ChangeEvent
+	id := bs.Subscribe(func(ev ChangeEvent) {
+		events = append(events, ev)
+	})
+	bs.SetBit(3)
+	bs.ResetBit(40)
+	bs.FlipRange(20, 2)
+	bs.SetVal(8, 15, 7)
+	bs.ClearAll()
+	other := NewBitset(2)
+	bs.Or(other)
+	bs.Resize(8)
+	expected := []ChangeEvent{
+		{Op: OpSet, Start: 3, End: 3, Size: 4},
+		{Op: OpFlip, Start: 2, End: 20, Size: 4},
+		{Op: OpSetVal, Start: 8, End: 15, Size: 4},
+		{Op: OpReset, Start: 0, End: 31, Size: 4},
+		{Op: OpOr, Start: 0, End: 15, Size: 4},
+		{Op: OpResize, Size: 8},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Subscribe failed, expected %d events, got %v", len(expected), events)
+	}
+	for i, ev := range events {
+		if ev != expected[i] {
+			t.Fatalf("Subscribe failed, expected %v, got %v", expected[i], ev)
+		}
+	}
+	bs.Unsubscribe(id)
+	bs.SetAll()
+	if len(events) != len(expected) {
+		t.Fatal("Unsubscribe failed")
+	}
+}
+
+func TestSubscribeChan(t *testing.T) {
+	bs := NewBitset(4)
+	events, cancel := bs.SubscribeChan()
+	for i := uint32(0); i < 32; i++ {
+		bs.SetBit(i)
+	}
+	bs.ClearRange(4, 12)
+	for i := uint32(0); i < 32; i++ {
+		ev := <-events
+		if ev.Op != OpSet || ev.Start != i || ev.End != i {
+			t.Fatalf("SubscribeChan failed, unexpected event %v", ev)
+		}
+	}
+	ev := <-events
+	if ev.Op != OpReset || ev.Start != 4 || ev.End != 12 {
+		t.Fatalf("SubscribeChan failed, unexpected event %v", ev)
+	}
+	cancel()
+	bs.SetAll()
+	if _, ok := <-events; ok {
+		t.Fatal("SubscribeChan failed, channel not closed after cancel")
+	}
+	cancel()
+}
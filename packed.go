@@ -0,0 +1,159 @@
+package bitset
+
+// PackedArray is an array of unsigned integers of a fixed width of 1 to 32 bits, packed back
+// to back in a Bitset with no padding. Element i occupies the bits i*width to
+// (i+1)*width-1, most significant bit first, as read and written by GetVal and SetVal. The
+// array grows by doubling its bitset when appending. It is safe to use from multiple
+// goroutines.
+type PackedArray struct {
+	bits   *Bitset
+	width  uint32
+	length uint32
+}
+
+// NewPackedArray returns an array of length zero elements of width bits. ErrMaxR is returned
+// if width is not between 1 and 32, ErrRange if the elements don't fit in a Bitset
+func NewPackedArray(width uint32, length uint32) (*PackedArray, error) {
+	if width == 0 || width > 32 {
+		return nil, ErrMaxR
+	}
+	size := (uint64(length)*uint64(width) + 7) >> 3
+	if size > maxAddressableBytes {
+		return nil, ErrRange
+	}
+	return &PackedArray{bits: NewBitset(uint32(size)), width: width, length: length}, nil
+}
+
+// NewPackedArrayFromSlice returns an array of elements of width bits holding values. The
+// values are truncated to their lowest width bits
+func NewPackedArrayFromSlice(width uint32, values []uint32) (*PackedArray, error) {
+	pa, err := NewPackedArray(width, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = pa.Load(values); err != nil {
+		return nil, err
+	}
+	return pa, nil
+}
+
+// Width returns the number of bits of an element
+func (pa *PackedArray) Width() uint32 {
+	return pa.width
+}
+
+// Len returns the number of elements of the array
+func (pa *PackedArray) Len() uint32 {
+	pa.bits.mutex.RLock()
+	defer pa.bits.mutex.RUnlock()
+	return pa.length
+}
+
+// Get returns the element at index i. ErrRange is returned if i is out of range
+func (pa *PackedArray) Get(i uint32) (uint32, error) {
+	pa.bits.mutex.RLock()
+	defer pa.bits.mutex.RUnlock()
+	if i >= pa.length {
+		return 0, ErrRange
+	}
+	return pa.bits.getVal(pa.field(i)), nil
+}
+
+// Set assigns the lowest width bits of v to the element at index i. ErrRange is returned if i
+// is out of range
+func (pa *PackedArray) Set(i uint32, v uint32) error {
+	pa.bits.mutex.Lock()
+	defer pa.bits.mutex.Unlock()
+	if i >= pa.length {
+		return ErrRange
+	}
+	start, end := pa.field(i)
+	pa.bits.setVal(start, end, v)
+	return nil
+}
+
+// Append adds the lowest width bits of v as a new element at the end of the array. ErrRange is
+// returned if the array can't grow any more
+func (pa *PackedArray) Append(v uint32) error {
+	pa.bits.mutex.Lock()
+	defer pa.bits.mutex.Unlock()
+	if err := pa.grow(uint64(pa.length) + 1); err != nil {
+		return err
+	}
+	start, end := pa.field(pa.length)
+	pa.length++
+	pa.bits.setVal(start, end, v)
+	return nil
+}
+
+// Load replaces the elements of the array with the lowest width bits of values. ErrRange is
+// returned and the array is left unchanged if values don't fit in a Bitset, the error of
+// Resize if the bitset could not be resized
+func (pa *PackedArray) Load(values []uint32) error {
+	size := (uint64(len(values))*uint64(pa.width) + 7) >> 3
+	if size > maxAddressableBytes {
+		return ErrRange
+	}
+	pa.bits.mutex.Lock()
+	defer pa.bits.mutex.Unlock()
+	if uint32(size) != pa.bits.size {
+		if err := pa.bits.resize(uint32(size)); err != nil {
+			return err
+		}
+	}
+	pa.length = uint32(len(values))
+	for i, v := range values {
+		start, end := pa.field(uint32(i))
+		pa.bits.setVal(start, end, v)
+	}
+	return nil
+}
+
+// Values returns a copy of the elements of the array
+func (pa *PackedArray) Values() []uint32 {
+	pa.bits.mutex.RLock()
+	defer pa.bits.mutex.RUnlock()
+	ret := make([]uint32, pa.length)
+	for i := range ret {
+		ret[i] = pa.bits.getVal(pa.field(uint32(i)))
+	}
+	return ret
+}
+
+// ForEach calls f with the index and the value of every element in order, until f returns
+// false. f must not modify the array
+func (pa *PackedArray) ForEach(f func(i uint32, v uint32) bool) {
+	pa.bits.mutex.RLock()
+	defer pa.bits.mutex.RUnlock()
+	for i := uint32(0); i < pa.length; i++ {
+		if !f(i, pa.bits.getVal(pa.field(i))) {
+			return
+		}
+	}
+}
+
+// field returns the first and last bit positions of the element at index i
+func (pa *PackedArray) field(i uint32) (uint32, uint32) {
+	start := i * pa.width
+	return start, start + pa.width - 1
+}
+
+// grow resizes the bitset, at least doubling it, if it can't hold length elements. The caller
+// must hold the write lock
+func (pa *PackedArray) grow(length uint64) error {
+	needed := (length*uint64(pa.width) + 7) >> 3
+	if needed <= uint64(pa.bits.size) {
+		return nil
+	}
+	if needed > maxAddressableBytes {
+		return ErrRange
+	}
+	newsize := uint64(pa.bits.size) * 2
+	if newsize < needed {
+		newsize = needed
+	}
+	if newsize > maxAddressableBytes {
+		newsize = maxAddressableBytes
+	}
+	return pa.bits.resize(uint32(newsize))
+}
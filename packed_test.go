@@ -0,0 +1,76 @@
+package bitset
+
+import (
+	"testing"
+)
+
+func TestPackedArray(t *testing.T) {
+	if _, err := NewPackedArray(33, 1); err != ErrMaxR {
+		t.Fatal("NewPackedArray failed to reject width 33")
+	}
+	if _, err := NewPackedArray(32, 1<<27+1); err != ErrRange {
+		t.Fatal("NewPackedArray failed to reject oversized array")
+	}
+	for _, width := range []uint32{1, 5, 11, 17, 32} {
+		pa, err := NewPackedArray(width, 10)
+		if err != nil || pa.Len() != 10 || pa.Width() != width {
+			t.Fatalf("NewPackedArray failed %v", err)
+		}
+		mask := ones32[width-1]
+		for i := uint32(0); i < 10; i++ {
+			if err = pa.Set(i, i*0x9e3779b9); err != nil {
+				t.Fatalf("Set failed %v", err)
+			}
+		}
+		for i := uint32(0); i < 1000; i++ {
+			if err = pa.Append(i * 0x85ebca6b); err != nil {
+				t.Fatalf("Append failed %v", err)
+			}
+		}
+		if pa.Len() != 1010 {
+			t.Fatalf("Append failed, got length %d", pa.Len())
+		}
+		for i := uint32(0); i < 1010; i++ {
+			expected := i * 0x9e3779b9 & mask
+			if i >= 10 {
+				expected = (i - 10) * 0x85ebca6b & mask
+			}
+			if v, err := pa.Get(i); err != nil || v != expected {
+				t.Fatalf("Get failed for width %d at %d, expected %x, got %x %v", width, i,
+					expected, v, err)
+			}
+		}
+		if _, err = pa.Get(1010); err != ErrRange {
+			t.Fatal("Get failed to reject index out of range")
+		}
+		if err = pa.Set(1010, 1); err != ErrRange {
+			t.Fatal("Set failed to reject index out of range")
+		}
+	}
+
+	values := []uint32{3, 1, 4, 1, 5, 9, 2, 6, 5, 3, 5}
+	pa, err := NewPackedArrayFromSlice(3, values)
+	if err != nil || pa.bits.GetSize() != 5 {
+		t.Fatalf("NewPackedArrayFromSlice failed %v", err)
+	}
+	got := pa.Values()
+	for i, v := range values {
+		if got[i] != v&7 {
+			t.Fatalf("Values failed at %d, expected %d, got %d", i, v&7, got[i])
+		}
+	}
+	var sum uint32 = 0
+	pa.ForEach(func(i uint32, v uint32) bool {
+		sum += v
+		return i < 3
+	})
+	if sum != 9 {
+		t.Fatalf("ForEach failed, got sum %d", sum)
+	}
+	if err = pa.Load([]uint32{7, 7}); err != nil || pa.Len() != 2 || pa.bits.GetSize() != 1 {
+		t.Fatalf("Load failed %v", err)
+	}
+	if v, _ := pa.Get(1); v != 7 {
+		t.Fatalf("Load failed, got %d", v)
+	}
+}
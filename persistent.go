@@ -0,0 +1,325 @@
+package bitset
+
+import (
+	"sync"
+)
+
+const (
+	// pleafbytes is the number of bytes held by a leaf of a PersistentBitset
+	pleafbytes = 64
+	pleafbits  = pleafbytes << 3
+	// pshift is log2 of the number of children of an inner node
+	pshift    = 5
+	pchildren = 1 << pshift
+)
+
+// pnode is a node of the trie of a PersistentBitset. Nodes are never modified once they are
+// reachable from a PersistentBitset, and a nil node stands for a subtree of zero bits
+type pnode struct {
+	children [pchildren]*pnode
+	leaf     []byte
+	count    uint64
+}
+
+// PersistentBitset is an immutable bitset where every modification returns a new version
+// sharing all the unchanged parts with the old one. The bits are stored in a trie of leaves of
+// 64 bytes, so a modification copies only the leaves it touches and their path to the root.
+// Bit positions are numbered the same way as in Bitset. All the versions can be used
+// concurrently without locking.
+type PersistentBitset struct {
+	size   uint32
+	height uint32
+	root   *pnode
+}
+
+// NewPersistentBitset returns a persistent bitset of size bytes with all the bits zero
+func NewPersistentBitset(size uint32) *PersistentBitset {
+	var height uint32 = 0
+	leaves := (uint64(size) + pleafbytes - 1) / pleafbytes
+	for leaves > 1 {
+		leaves = (leaves + pchildren - 1) >> pshift
+		height++
+	}
+	return &PersistentBitset{size: size, height: height}
+}
+
+// NewPersistentBitsetFromArray returns a persistent bitset holding a copy of the passed slice
+func NewPersistentBitsetFromArray(input []byte) *PersistentBitset {
+	pb := NewPersistentBitset(uint32(len(input)))
+	if len(input) == 0 {
+		return pb
+	}
+	pb.root = pb.modify(0, uint64(len(input))<<3-1, func(leaf []byte, offset uint64, first uint32, last uint32) {
+		copy(leaf, input[offset>>3:])
+	})
+	return pb
+}
+
+// Persistent returns a persistent copy of the bitset. A persistent bitset numbers its bits
+// MSB-first, so the bytes of a LSB-first bitset are copied with their bits reversed and every
+// bit keeps its position
+func (bs *Bitset) Persistent() *PersistentBitset {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if bs.order == LSBFirst {
+		buf := make([]byte, bs.size)
+		for i, b := range bs.buf {
+			buf[i] = reversed[b]
+		}
+		return NewPersistentBitsetFromArray(buf)
+	}
+	return NewPersistentBitsetFromArray(bs.buf)
+}
+
+// GetSize returns the size of the bitset in bytes
+func (pb *PersistentBitset) GetSize() uint32 {
+	return pb.size
+}
+
+// IsSet returns true if the bit is set at position, false otherwise. error retuned will be
+// non-nil if the position exceeds the bitset capacity, nil otherwise
+func (pb *PersistentBitset) IsSet(position uint32) (bool, error) {
+	if position>>3 >= pb.size {
+		return false, ErrRange
+	}
+	n := pb.root
+	for h := pb.height; n != nil && h > 0; h-- {
+		n = n.children[(uint64(position)/pleafbits>>(pshift*(h-1)))&(pchildren-1)]
+	}
+	if n == nil {
+		return false, nil
+	}
+	bitpos := position % pleafbits
+	return n.leaf[bitpos>>3]&ones[7-(bitpos&7)] != 0, nil
+}
+
+// GetSetbitCount returns the number of set or 1 bits in the bitset
+func (pb *PersistentBitset) GetSetbitCount() uint64 {
+	if pb.root == nil {
+		return 0
+	}
+	return pb.root.count
+}
+
+// GetZerobitCount returns the number of 0 bits in the bitset
+func (pb *PersistentBitset) GetZerobitCount() uint64 {
+	return (uint64(pb.size) << 3) - pb.GetSetbitCount()
+}
+
+// SetBit returns a new version with the bit at position set. Non-nil error is returned if the
+// position is out of range
+func (pb *PersistentBitset) SetBit(position uint32) (*PersistentBitset, error) {
+	return pb.SetRange(position, position)
+}
+
+// ResetBit returns a new version with the bit at position reset. Non-nil error is returned if
+// the position is out of range
+func (pb *PersistentBitset) ResetBit(position uint32) (*PersistentBitset, error) {
+	return pb.ClearRange(position, position)
+}
+
+// SetRange returns a new version with the bits in positions start <= position <= end set. It
+// returns non-nil error if any of the position passed is out of range
+func (pb *PersistentBitset) SetRange(start uint32, end uint32) (*PersistentBitset, error) {
+	if start > end {
+		start, end = end, start
+	}
+	if end>>3 >= pb.size {
+		return nil, ErrRange
+	}
+	root := pb.modify(uint64(start), uint64(end), func(leaf []byte, offset uint64, first uint32, last uint32) {
+		for i := first >> 3; i <= last>>3; i++ {
+			leaf[i] |= rangeMask(i, first, last)
+		}
+	})
+	return &PersistentBitset{size: pb.size, height: pb.height, root: root}, nil
+}
+
+// ClearRange returns a new version with the bits in positions start <= position <= end reset.
+// It returns non-nil error if any of the position passed is out of range
+func (pb *PersistentBitset) ClearRange(start uint32, end uint32) (*PersistentBitset, error) {
+	if start > end {
+		start, end = end, start
+	}
+	if end>>3 >= pb.size {
+		return nil, ErrRange
+	}
+	root := pb.modify(uint64(start), uint64(end), func(leaf []byte, offset uint64, first uint32, last uint32) {
+		for i := first >> 3; i <= last>>3; i++ {
+			leaf[i] &= ^rangeMask(i, first, last)
+		}
+	})
+	return &PersistentBitset{size: pb.size, height: pb.height, root: root}, nil
+}
+
+// ToBitset returns a mutable Bitset holding a copy of the bits
+func (pb *PersistentBitset) ToBitset() *Bitset {
+	bs := NewBitset(pb.size)
+	pb.root.leaves(pb.height, 0, func(offset uint64, leaf []byte) {
+		copy(bs.buf[offset:], leaf)
+	})
+	return bs
+}
+
+// modify returns the root of a copy of the trie where f was applied to the leaves holding the
+// bit positions start <= position <= end. f gets a private copy of the leaf, the position of
+// the first bit of the leaf and the first and last bit positions to modify within the leaf
+func (pb *PersistentBitset) modify(start uint64, end uint64,
+	f func(leaf []byte, offset uint64, first uint32, last uint32)) *pnode {
+	return pb.root.modify(pb.height, 0, start, end, f)
+}
+
+func (n *pnode) modify(height uint32, offset uint64, start uint64, end uint64,
+	f func(leaf []byte, offset uint64, first uint32, last uint32)) *pnode {
+	cp := &pnode{}
+	if n != nil {
+		*cp = *n
+	}
+	span := uint64(pleafbits) << (pshift * height)
+	first := offset
+	if start > first {
+		first = start
+	}
+	last := offset + span - 1
+	if end < last {
+		last = end
+	}
+	if height == 0 {
+		cp.leaf = make([]byte, pleafbytes)
+		if n != nil {
+			copy(cp.leaf, n.leaf)
+		}
+		f(cp.leaf, offset, uint32(first-offset), uint32(last-offset))
+		cp.count = 0
+		for _, b := range cp.leaf {
+			cp.count += uint64(setbits[b])
+		}
+		return cp
+	}
+	childspan := span >> pshift
+	for i := (first - offset) / childspan; i <= (last-offset)/childspan; i++ {
+		cp.children[i] = cp.children[i].modify(height-1, offset+i*childspan, start, end, f)
+	}
+	cp.count = 0
+	for _, child := range cp.children {
+		if child != nil {
+			cp.count += child.count
+		}
+	}
+	return cp
+}
+
+// leaves calls f with the byte offset and contents of every non-nil leaf in order
+func (n *pnode) leaves(height uint32, offset uint64, f func(offset uint64, leaf []byte)) {
+	if n == nil {
+		return
+	}
+	if height == 0 {
+		f(offset, n.leaf)
+		return
+	}
+	childspan := uint64(pleafbytes) << (pshift * (height - 1))
+	for i, child := range n.children {
+		child.leaves(height-1, offset+uint64(i)*childspan, f)
+	}
+}
+
+// History keeps the versions of a PersistentBitset for undo and redo. As the versions share
+// their unchanged parts, keeping a version costs memory proportional to the bits it changed.
+// It is safe to use from multiple goroutines
+type History struct {
+	mutex    sync.RWMutex
+	versions []*PersistentBitset
+	current  int
+}
+
+// NewHistory returns a history whose version 0 is initial
+func NewHistory(initial *PersistentBitset) *History {
+	return &History{versions: []*PersistentBitset{initial}}
+}
+
+// Current returns the current version
+func (h *History) Current() *PersistentBitset {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.versions[h.current]
+}
+
+// CurrentVersion returns the number of the current version
+func (h *History) CurrentVersion() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.current
+}
+
+// Len returns the number of versions kept
+func (h *History) Len() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.versions)
+}
+
+// Commit makes pb the current version and returns its number. The versions after the current
+// one, which could be reached by Redo, are dropped
+func (h *History) Commit(pb *PersistentBitset) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.commit(pb)
+}
+
+// Update applies f to the current version and commits the version it returns. If f returns
+// non-nil error, nothing is committed and the error is returned
+func (h *History) Update(f func(pb *PersistentBitset) (*PersistentBitset, error)) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	pb, err := f(h.versions[h.current])
+	if err != nil {
+		return err
+	}
+	h.commit(pb)
+	return nil
+}
+
+// Undo moves to the previous version and returns it. It returns false if the current version
+// is the first one
+func (h *History) Undo() (*PersistentBitset, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.current == 0 {
+		return h.versions[0], false
+	}
+	h.current--
+	return h.versions[h.current], true
+}
+
+// Redo moves to the version undone last and returns it. It returns false if there is no such
+// version
+func (h *History) Redo() (*PersistentBitset, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.current+1 == len(h.versions) {
+		return h.versions[h.current], false
+	}
+	h.current++
+	return h.versions[h.current], true
+}
+
+// Rewind makes the version numbered version current and returns it. The later versions are
+// kept and can be reached by Redo or Rewind until the next Commit. ErrRange is returned if
+// there is no such version
+func (h *History) Rewind(version int) (*PersistentBitset, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if version < 0 || version >= len(h.versions) {
+		return nil, ErrRange
+	}
+	h.current = version
+	return h.versions[version], nil
+}
+
+// commit does the work of Commit, the caller must hold the write lock
+func (h *History) commit(pb *PersistentBitset) int {
+	h.versions = append(h.versions[:h.current+1], pb)
+	h.current++
+	return h.current
+}
@@ -0,0 +1,104 @@
+package bitset
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPersistentBitset(t *testing.T) {
+	v0 := NewPersistentBitset(5000)
+	v1, err := v0.SetBit(4000 * 8)
+	if err != nil {
+		t.Fatalf("SetBit failed %v", err)
+	}
+	v2, err := v1.SetRange(10, 9000)
+	if err != nil {
+		t.Fatalf("SetRange failed %v", err)
+	}
+	v3, err := v2.ClearRange(100, 200)
+	if err != nil {
+		t.Fatalf("ClearRange failed %v", err)
+	}
+	if v0.GetSetbitCount() != 0 || v1.GetSetbitCount() != 1 || v2.GetSetbitCount() != 8992 ||
+		v3.GetSetbitCount() != 8891 {
+		t.Fatalf("GetSetbitCount failed, got %d %d %d %d", v0.GetSetbitCount(),
+			v1.GetSetbitCount(), v2.GetSetbitCount(), v3.GetSetbitCount())
+	}
+	if ret, _ := v1.IsSet(10); ret {
+		t.Fatal("SetRange modified the old version")
+	}
+	if ret, _ := v2.IsSet(150); !ret {
+		t.Fatal("ClearRange modified the old version")
+	}
+	if ret, _ := v3.IsSet(150); ret {
+		t.Fatal("ClearRange failed")
+	}
+	if ret, _ := v3.IsSet(4000 * 8); !ret {
+		t.Fatal("IsSet failed")
+	}
+	if _, err = v3.SetBit(5000 * 8); err != ErrRange {
+		t.Fatal("SetBit failed to detect invalid position")
+	}
+
+	// the versions share the leaves which were not modified
+	if v3.root.children[1] != v2.root.children[1] {
+		t.Fatal("ClearRange failed to share unchanged nodes")
+	}
+
+	bs := NewBitset(5000)
+	bs.SetBit(4000 * 8)
+	bs.SetRange(10, 9000)
+	bs.ClearRange(100, 200)
+	if !bytes.Equal(v3.ToBitset().GetBytes(), bs.GetBytes()) {
+		t.Fatal("ToBitset failed")
+	}
+	if !bytes.Equal(bs.Persistent().ToBitset().GetBytes(), bs.GetBytes()) {
+		t.Fatal("Persistent failed")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(NewPersistentBitset(10))
+	for i := uint32(0); i < 5; i++ {
+		err := h.Update(func(pb *PersistentBitset) (*PersistentBitset, error) {
+			return pb.SetBit(i)
+		})
+		if err != nil {
+			t.Fatalf("Update failed %v", err)
+		}
+	}
+	if h.Len() != 6 || h.CurrentVersion() != 5 || h.Current().GetSetbitCount() != 5 {
+		t.Fatal("Update failed")
+	}
+	if err := h.Update(func(pb *PersistentBitset) (*PersistentBitset, error) {
+		return pb.SetBit(80)
+	}); err != ErrRange || h.Len() != 6 {
+		t.Fatal("Update failed to detect error")
+	}
+	pb, ok := h.Undo()
+	if !ok || pb.GetSetbitCount() != 4 {
+		t.Fatal("Undo failed")
+	}
+	pb, ok = h.Redo()
+	if !ok || pb.GetSetbitCount() != 5 {
+		t.Fatal("Redo failed")
+	}
+	if _, ok = h.Redo(); ok {
+		t.Fatal("Redo failed, no version to redo")
+	}
+	pb, err := h.Rewind(2)
+	if err != nil || pb.GetSetbitCount() != 2 {
+		t.Fatal("Rewind failed")
+	}
+	pb, _ = pb.SetRange(40, 79)
+	if h.Commit(pb) != 3 || h.Len() != 4 {
+		t.Fatal("Commit failed to drop the undone versions")
+	}
+	if _, err = h.Rewind(4); err != ErrRange {
+		t.Fatal("Rewind failed to detect invalid version")
+	}
+	h.Rewind(0)
+	if _, ok = h.Undo(); ok {
+		t.Fatal("Undo failed, no version to undo")
+	}
+}
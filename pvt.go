@@ -5,17 +5,25 @@ import (
 )
 
 var (
-	ones     []byte
-	zeros    []byte
-	ones32   []uint32
-	setbits  []byte
-	zerobits []byte
-	leftmz   []byte
-	rightmz  []byte
-	leftm1   []byte
-	rightm1  []byte
-	ErrRange = errors.New("Index out of range")
-	ErrMaxR  = errors.New("Maximum bit range allowed for GetVal and SetVal is 32")
+	ones            []byte
+	zeros           []byte
+	ones32          []uint32
+	setbits         []byte
+	zerobits        []byte
+	leftmz          []byte
+	rightmz         []byte
+	leftm1          []byte
+	rightm1         []byte
+	reversed        []byte
+	ErrRange        = errors.New("Index out of range")
+	ErrMaxR         = errors.New("Maximum bit range allowed for GetVal and SetVal is 32")
+	ErrFull         = errors.New("No free bit available")
+	ErrNotAllocated = errors.New("Bit is not allocated")
+	ErrFileFormat   = errors.New("Not a bitset file")
+	ErrNotSupported = errors.New("Not supported on this platform")
+	ErrIncompatible = errors.New("Incompatible parameters")
+	ErrSyntax       = errors.New("Syntax error")
+	ErrClosed       = errors.New("Bitset file is no longer mapped")
 )
 
 const (
@@ -24,6 +32,9 @@ const (
 	xor
 )
 
+// maxAddressableBytes is the largest size of a bitset all of whose bits have a uint32 position
+const maxAddressableBytes = 1 << 29
+
 func init() {
 	ones = make([]byte, 8)
 	zeros = make([]byte, 8)
@@ -33,6 +44,7 @@ func init() {
 	leftm1 = make([]byte, 256)
 	leftmz = make([]byte, 256)
 	rightmz = make([]byte, 256)
+	reversed = make([]byte, 256)
 
 	ones[0] = 1
 	zeros[0] = 254
@@ -53,6 +65,7 @@ func init() {
 	for i < 256 {
 		setbits[i] = byte(i&1) + setbits[i>>uint32(1)]
 		zerobits[i] = 8 - setbits[i]
+		reversed[i] = reversed[i>>uint32(1)]>>1 | byte(i&1)<<7
 		i++
 	}
 	i = 0
@@ -0,0 +1,609 @@
+package bitset
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+)
+
+// RedisBitmap mirrors a Redis string used as a bitmap, with the exact semantics of the Redis
+// bit commands SETBIT, GETBIT, BITCOUNT, BITPOS, BITOP and BITFIELD. Its Bitset numbers bits
+// most significant bit first as Redis does, so the bytes are the value of the Redis string.
+// Like a Redis string it grows to the byte holding the highest bit written and never shrinks
+// on its own, which matters as negative indices count from its end. An empty bitmap stands
+// for a missing key. It is safe to use from multiple goroutines.
+type RedisBitmap struct {
+	bits *Bitset
+}
+
+// RangeUnit tells whether the start and end indices of BitCountRange and BitPosRange count
+// bytes or bits
+type RangeUnit int
+
+const (
+	// ByteUnit counts bytes, the default of Redis
+	ByteUnit RangeUnit = iota
+	// BitUnit counts bits
+	BitUnit
+)
+
+// BitOp is the operation of the BITOP command
+type BitOp int
+
+const (
+	// BitOpAnd ands the sources
+	BitOpAnd BitOp = iota
+	// BitOpOr ors the sources
+	BitOpOr
+	// BitOpXor xors the sources
+	BitOpXor
+	// BitOpNot inverts its single source
+	BitOpNot
+)
+
+// BitfieldOverflow is the behavior of BITFIELD SET and INCRBY when the value doesn't fit in
+// the field
+type BitfieldOverflow int
+
+const (
+	// OverflowWrap keeps the lowest bits of the value, the default of Redis
+	OverflowWrap BitfieldOverflow = iota
+	// OverflowSat saturates to the minimum or maximum value of the field
+	OverflowSat
+	// OverflowFail leaves the field unchanged and returns no value
+	OverflowFail
+)
+
+// BitfieldType is the type of a BITFIELD field, a signed integer of 1 to 64 bits or an
+// unsigned one of 1 to 63 bits
+type BitfieldType struct {
+	Signed bool
+	Bits   uint32
+}
+
+// maxRedisBits is the number of bits of the largest Redis string, 512MB
+const maxRedisBits = maxAddressableBytes << 3
+
+// NewRedisBitmap returns an empty bitmap
+func NewRedisBitmap() *RedisBitmap {
+	return &RedisBitmap{bits: NewBitset(0)}
+}
+
+// NewRedisBitmapFromBytes returns a bitmap holding a copy of value, as after SET. ErrRange is
+// returned if value is larger than 512MB
+func NewRedisBitmapFromBytes(value []byte) (*RedisBitmap, error) {
+	rb := NewRedisBitmap()
+	if err := rb.Set(value); err != nil {
+		return nil, err
+	}
+	return rb, nil
+}
+
+// Bitset returns the bitset holding the bitmap
+func (rb *RedisBitmap) Bitset() *Bitset {
+	return rb.bits
+}
+
+// Len returns the length of the string in bytes, as STRLEN
+func (rb *RedisBitmap) Len() uint32 {
+	return rb.bits.GetSize()
+}
+
+// Bytes returns a copy of the string, as GET
+func (rb *RedisBitmap) Bytes() []byte {
+	return rb.bits.GetBytes()
+}
+
+// Set replaces the string with a copy of value, as SET. ErrRange is returned if value is
+// larger than 512MB
+func (rb *RedisBitmap) Set(value []byte) error {
+	if uint64(len(value)) > maxAddressableBytes {
+		return ErrRange
+	}
+	rb.bits.mutex.Lock()
+	defer rb.bits.mutex.Unlock()
+	return rb.replace(value)
+}
+
+// SetBit sets or clears the bit at offset, growing the string if needed, and returns the
+// previous value of the bit, as SETBIT. The error of Resize is returned if the string could
+// not grow
+func (rb *RedisBitmap) SetBit(offset uint32, value bool) (bool, error) {
+	bs := rb.bits
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if err := rb.grow(offset>>3 + 1); err != nil {
+		return false, err
+	}
+	old := bs.isSet(offset)
+	if value && !old {
+		bs.setBit(offset)
+	} else if !value && old {
+		bs.clearRange(offset, offset)
+	}
+	return old, nil
+}
+
+// GetBit returns the bit at offset, false beyond the end of the string, as GETBIT
+func (rb *RedisBitmap) GetBit(offset uint32) bool {
+	bs := rb.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if offset>>3 >= bs.size {
+		return false
+	}
+	return bs.isSet(offset)
+}
+
+// BitCount returns the number of set bits of the string, as BITCOUNT without a range
+func (rb *RedisBitmap) BitCount() uint64 {
+	return rb.bits.GetSetbitCount()
+}
+
+// BitCountRange returns the number of set bits from the start to the end index included,
+// counted in unit, as BITCOUNT with a range. Negative indices count from the end of the
+// string, -1 being the last byte or bit
+func (rb *RedisBitmap) BitCountRange(start int64, end int64, unit RangeUnit) uint64 {
+	bs := rb.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	first, last, ok := rb.bitRange(start, end, unit)
+	if !ok {
+		return 0
+	}
+	var ret uint64 = 0
+	for i := first >> 3; i <= last>>3; i++ {
+		ret += uint64(setbits[bs.buf[i]&rangeMask(i, first, last)])
+	}
+	return ret
+}
+
+// BitPos returns the position of the first bit of the string equal to bit, as BITPOS without
+// a range. When looking for a zero bit in a string of set bits the position right after its
+// end is returned, as if the string were padded with zeros. -1 is returned if there is no
+// such bit
+func (rb *RedisBitmap) BitPos(bit bool) int64 {
+	return rb.BitPosFrom(bit, 0)
+}
+
+// BitPosFrom returns the position of the first bit equal to bit from the start byte, which
+// counts from the end of the string if negative, as BITPOS with only a start. Zero bits are
+// looked for beyond the end of the string like BitPos does
+func (rb *RedisBitmap) BitPosFrom(bit bool, start int64) int64 {
+	return rb.bitPos(bit, start, -1, ByteUnit, false)
+}
+
+// BitPosRange returns the position of the first bit equal to bit from the start to the end
+// index included, counted in unit, as BITPOS with a range. Negative indices count from the
+// end of the string. -1 is returned if there is no such bit in the range
+func (rb *RedisBitmap) BitPosRange(bit bool, start int64, end int64, unit RangeUnit) int64 {
+	return rb.bitPos(bit, start, end, unit, true)
+}
+
+// bitPos does the work of BitPos, BitPosFrom and BitPosRange
+func (rb *RedisBitmap) bitPos(bit bool, start int64, end int64, unit RangeUnit, endgiven bool) int64 {
+	bs := rb.bits
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if bs.size == 0 {
+		// a missing key holds only zeros
+		if bit {
+			return -1
+		}
+		return 0
+	}
+	first, last, ok := rb.bitRange(start, end, unit)
+	if !ok {
+		return -1
+	}
+	for i := first >> 3; i <= last>>3; i++ {
+		b := bs.buf[i]
+		if !bit {
+			b = ^b
+		}
+		b &= rangeMask(i, first, last)
+		if b != 0 {
+			return int64(i)<<3 + 7 - int64(leftm1[b])
+		}
+	}
+	if !bit && !endgiven {
+		return int64(bs.size) << 3
+	}
+	return -1
+}
+
+// bitRange converts start and end indices counted in unit to the positions of the first and
+// last bits of the range, clamped to the string. ok is false if the range is empty. The caller
+// must hold the lock
+func (rb *RedisBitmap) bitRange(start int64, end int64, unit RangeUnit) (uint32, uint32, bool) {
+	total := int64(rb.bits.size)
+	if unit == BitUnit {
+		total <<= 3
+	}
+	if start < 0 {
+		start += total
+	}
+	if end < 0 {
+		end += total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if start > end {
+		return 0, 0, false
+	}
+	if unit == BitUnit {
+		return uint32(start), uint32(end), true
+	}
+	return uint32(start << 3), uint32(end<<3 + 7), true
+}
+
+// BitOp stores the result of op on the strings of srcs in the bitmap and returns its length,
+// as BITOP. Shorter strings are padded with zeros to the length of the longest one. BitOpNot
+// takes exactly one source. ErrSyntax is returned if op is unknown or there are no sources.
+// Each source is read under its own lock, so the bitmap may be one of them
+func (rb *RedisBitmap) BitOp(op BitOp, srcs ...*RedisBitmap) (uint32, error) {
+	if op < BitOpAnd || op > BitOpNot || len(srcs) == 0 || (op == BitOpNot && len(srcs) != 1) {
+		return 0, ErrSyntax
+	}
+	values := make([][]byte, len(srcs))
+	maxlen := 0
+	for i, src := range srcs {
+		values[i] = src.bits.GetBytes()
+		if len(values[i]) > maxlen {
+			maxlen = len(values[i])
+		}
+	}
+	res := make([]byte, maxlen)
+	copy(res, values[0])
+	if op == BitOpNot {
+		for i := range res {
+			res[i] = ^res[i]
+		}
+	}
+	for _, v := range values[1:] {
+		for i := range res {
+			var b byte = 0
+			if i < len(v) {
+				b = v[i]
+			}
+			switch op {
+			case BitOpAnd:
+				res[i] &= b
+			case BitOpOr:
+				res[i] |= b
+			case BitOpXor:
+				res[i] ^= b
+			}
+		}
+	}
+	rb.bits.mutex.Lock()
+	defer rb.bits.mutex.Unlock()
+	if err := rb.replace(res); err != nil {
+		return 0, err
+	}
+	return uint32(maxlen), nil
+}
+
+// ParseBitfieldType parses a BITFIELD type such as i8 or u16
+func ParseBitfieldType(s string) (BitfieldType, error) {
+	var t BitfieldType
+	if len(s) < 2 {
+		return t, ErrSyntax
+	}
+	switch s[0] {
+	case 'i', 'I':
+		t.Signed = true
+	case 'u', 'U':
+	default:
+		return t, ErrSyntax
+	}
+	n, err := strconv.ParseUint(s[1:], 10, 32)
+	if err != nil {
+		return t, ErrSyntax
+	}
+	t.Bits = uint32(n)
+	if !t.valid() {
+		return t, ErrSyntax
+	}
+	return t, nil
+}
+
+// ParseBitfieldOffset parses the offset of a BITFIELD field of type t, either a bit position
+// or a field index prefixed by # that is multiplied by the width of the type. ErrRange is
+// returned if the field doesn't fit in a 512MB string
+func ParseBitfieldOffset(s string, t BitfieldType) (uint32, error) {
+	if !t.valid() {
+		return 0, ErrSyntax
+	}
+	index := strings.HasPrefix(s, "#")
+	if index {
+		s = s[1:]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n >= maxRedisBits {
+		return 0, ErrRange
+	}
+	if index {
+		n *= uint64(t.Bits)
+	}
+	if n+uint64(t.Bits) > maxRedisBits {
+		return 0, ErrRange
+	}
+	return uint32(n), nil
+}
+
+// BitfieldGet returns the field of type t at offset, reading zeros beyond the end of the
+// string, as BITFIELD GET. ErrSyntax is returned if t is invalid, ErrRange if the field
+// doesn't fit in a 512MB string
+func (rb *RedisBitmap) BitfieldGet(t BitfieldType, offset uint32) (int64, error) {
+	if err := t.check(offset); err != nil {
+		return 0, err
+	}
+	rb.bits.mutex.RLock()
+	defer rb.bits.mutex.RUnlock()
+	return rb.bitfieldGet(t, offset), nil
+}
+
+// BitfieldSet assigns value to the field of type t at offset and returns the previous value of
+// the field, as BITFIELD SET. A value that doesn't fit in the field is handled according to
+// overflow, false is returned and nothing is written if it fails. Like Redis the string grows
+// to hold the field even then
+func (rb *RedisBitmap) BitfieldSet(t BitfieldType, offset uint32, value int64, overflow BitfieldOverflow) (int64, bool, error) {
+	if err := t.check(offset); err != nil {
+		return 0, false, err
+	}
+	rb.bits.mutex.Lock()
+	defer rb.bits.mutex.Unlock()
+	if err := rb.grow(t.size(offset)); err != nil {
+		return 0, false, err
+	}
+	old, ok := rb.bitfieldSet(t, offset, value, overflow)
+	return old, ok, nil
+}
+
+// BitfieldIncrBy adds incr to the field of type t at offset and returns the new value of the
+// field, as BITFIELD INCRBY. An overflow is handled according to overflow, false is returned
+// and nothing is written if it fails. Like Redis the string grows to hold the field even then
+func (rb *RedisBitmap) BitfieldIncrBy(t BitfieldType, offset uint32, incr int64, overflow BitfieldOverflow) (int64, bool, error) {
+	if err := t.check(offset); err != nil {
+		return 0, false, err
+	}
+	rb.bits.mutex.Lock()
+	defer rb.bits.mutex.Unlock()
+	if err := rb.grow(t.size(offset)); err != nil {
+		return 0, false, err
+	}
+	v, ok := rb.bitfieldIncrBy(t, offset, incr, overflow)
+	return v, ok, nil
+}
+
+// Bitfield runs the subcommands of a BITFIELD command given as its arguments after the key,
+// such as "INCRBY", "i5", "100", "1", "GET", "u4", "0", and returns their results in order.
+// The results of GET, SET and INCRBY are nil where an overflow fails, OVERFLOW has none. All
+// the arguments are checked before running anything, and the subcommands run atomically
+func (rb *RedisBitmap) Bitfield(args ...string) ([]*int64, error) {
+	type subcommand struct {
+		name     string
+		t        BitfieldType
+		offset   uint32
+		arg      int64
+		overflow BitfieldOverflow
+	}
+	var subs []subcommand
+	overflow := OverflowWrap
+	writes := false
+	// like Redis the string grows to hold all the fields written before running anything,
+	// even the writes that fail
+	var size uint32 = 0
+	for i := 0; i < len(args); {
+		name := strings.ToUpper(args[i])
+		switch name {
+		case "OVERFLOW":
+			if i+1 >= len(args) {
+				return nil, ErrSyntax
+			}
+			switch strings.ToUpper(args[i+1]) {
+			case "WRAP":
+				overflow = OverflowWrap
+			case "SAT":
+				overflow = OverflowSat
+			case "FAIL":
+				overflow = OverflowFail
+			default:
+				return nil, ErrSyntax
+			}
+			i += 2
+			continue
+		case "GET":
+			if i+2 >= len(args) {
+				return nil, ErrSyntax
+			}
+		case "SET", "INCRBY":
+			if i+3 >= len(args) {
+				return nil, ErrSyntax
+			}
+			writes = true
+		default:
+			return nil, ErrSyntax
+		}
+		t, err := ParseBitfieldType(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		offset, err := ParseBitfieldOffset(args[i+2], t)
+		if err != nil {
+			return nil, err
+		}
+		sub := subcommand{name: name, t: t, offset: offset, overflow: overflow}
+		i += 3
+		if name != "GET" {
+			if sub.arg, err = strconv.ParseInt(args[i], 10, 64); err != nil {
+				return nil, ErrSyntax
+			}
+			i++
+			if needed := t.size(offset); needed > size {
+				size = needed
+			}
+		}
+		subs = append(subs, sub)
+	}
+	if writes {
+		rb.bits.mutex.Lock()
+		defer rb.bits.mutex.Unlock()
+		if err := rb.grow(size); err != nil {
+			return nil, err
+		}
+	} else {
+		rb.bits.mutex.RLock()
+		defer rb.bits.mutex.RUnlock()
+	}
+	ret := make([]*int64, len(subs))
+	for i, sub := range subs {
+		v, ok := int64(0), true
+		switch sub.name {
+		case "GET":
+			v = rb.bitfieldGet(sub.t, sub.offset)
+		case "SET":
+			v, ok = rb.bitfieldSet(sub.t, sub.offset, sub.arg, sub.overflow)
+		case "INCRBY":
+			v, ok = rb.bitfieldIncrBy(sub.t, sub.offset, sub.arg, sub.overflow)
+		}
+		if ok {
+			ret[i] = &v
+		}
+	}
+	return ret, nil
+}
+
+// bitfieldGet does the work of BitfieldGet, the caller must hold the lock
+func (rb *RedisBitmap) bitfieldGet(t BitfieldType, offset uint32) int64 {
+	v := rb.bits.getVal64(offset, offset+t.Bits-1, MSBFirst)
+	if t.Signed {
+		shift := 64 - t.Bits
+		return int64(v<<shift) >> shift
+	}
+	return int64(v)
+}
+
+// bitfieldSet does the work of BitfieldSet on a string holding the field, the caller must hold
+// the write lock
+func (rb *RedisBitmap) bitfieldSet(t BitfieldType, offset uint32, value int64, overflow BitfieldOverflow) (int64, bool) {
+	old := rb.bitfieldGet(t, offset)
+	v, ok := t.add(value, 0, overflow)
+	if !ok {
+		return 0, false
+	}
+	rb.bitfieldWrite(t, offset, v)
+	return old, true
+}
+
+// bitfieldIncrBy does the work of BitfieldIncrBy on a string holding the field, the caller must
+// hold the write lock
+func (rb *RedisBitmap) bitfieldIncrBy(t BitfieldType, offset uint32, incr int64, overflow BitfieldOverflow) (int64, bool) {
+	v, ok := t.add(rb.bitfieldGet(t, offset), incr, overflow)
+	if !ok {
+		return 0, false
+	}
+	rb.bitfieldWrite(t, offset, v)
+	return v, true
+}
+
+// bitfieldWrite assigns the lowest bits of v to the field of type t at offset, the caller must
+// hold the write lock
+func (rb *RedisBitmap) bitfieldWrite(t BitfieldType, offset uint32, v int64) {
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(v)<<(64-t.Bits))
+	rb.bits.writeBits(offset, data[:], t.Bits, MSBFirst)
+}
+
+// grow extends the string with zeros to size bytes if it is shorter, the caller must hold the
+// write lock
+func (rb *RedisBitmap) grow(size uint32) error {
+	if size > rb.bits.size {
+		return rb.bits.resize(size)
+	}
+	return nil
+}
+
+// replace replaces the content of the string with value, the caller must hold the write lock
+func (rb *RedisBitmap) replace(value []byte) error {
+	bs := rb.bits
+	if uint32(len(value)) != bs.size {
+		if err := bs.resize(uint32(len(value))); err != nil {
+			return err
+		}
+	}
+	bs.beforeWriteAll()
+	copy(bs.buf, value)
+	bs.modifiedAll(OpSetVal)
+	return nil
+}
+
+// valid returns true if t is a type BITFIELD accepts
+func (t BitfieldType) valid() bool {
+	if t.Signed {
+		return t.Bits >= 1 && t.Bits <= 64
+	}
+	return t.Bits >= 1 && t.Bits <= 63
+}
+
+// size returns the length of the shortest string holding the field of type t at offset
+func (t BitfieldType) size(offset uint32) uint32 {
+	return (offset+t.Bits-1)>>3 + 1
+}
+
+// check returns the error for a field of type t at offset if there is one
+func (t BitfieldType) check(offset uint32) error {
+	if !t.valid() {
+		return ErrSyntax
+	}
+	if uint64(offset)+uint64(t.Bits) > maxRedisBits {
+		return ErrRange
+	}
+	return nil
+}
+
+// add returns value + incr as a value of type t, handling an overflow according to overflow.
+// An unsigned value is taken as a uint64, so a negative value to SET overflows. false is
+// returned if the overflow fails
+func (t BitfieldType) add(value int64, incr int64, overflow BitfieldOverflow) (int64, bool) {
+	var up, down bool
+	var wrapped int64
+	var min, max int64
+	sum := uint64(value) + uint64(incr)
+	if t.Signed {
+		max = int64(^uint64(0) >> (65 - t.Bits))
+		min = -max - 1
+		up = value > max || (incr > 0 && value > max-incr)
+		down = value < min || (incr < 0 && value < min-incr)
+		shift := 64 - t.Bits
+		wrapped = int64(sum<<shift) >> shift
+	} else {
+		umax := uint64(1)<<t.Bits - 1
+		v := uint64(value)
+		max = int64(umax)
+		up = v > umax || (incr > 0 && uint64(incr) > umax-v)
+		down = !up && incr < 0 && -uint64(incr) > v
+		wrapped = int64(sum & umax)
+	}
+	if !up && !down {
+		return int64(sum), true
+	}
+	switch overflow {
+	case OverflowSat:
+		if up {
+			return max, true
+		}
+		return min, true
+	case OverflowFail:
+		return 0, false
+	}
+	return wrapped, true
+}
@@ -0,0 +1,238 @@
+package bitset
+
+import (
+	"testing"
+)
+
+// the examples below are those of the Redis documentation of each command
+
+func TestRedisSetBitGetBit(t *testing.T) {
+	rb := NewRedisBitmap()
+	if old, err := rb.SetBit(7, true); old || err != nil {
+		t.Fatal("SETBIT failed to return the previous bit")
+	}
+	if old, _ := rb.SetBit(7, false); !old {
+		t.Fatal("SETBIT failed to return the previous bit")
+	}
+	if b := rb.Bytes(); len(b) != 1 || b[0] != 0 {
+		t.Fatalf("SETBIT failed, got %q", b)
+	}
+	rb.SetBit(7, true)
+	if rb.GetBit(0) || !rb.GetBit(7) || rb.GetBit(100) {
+		t.Fatal("GETBIT failed")
+	}
+	rb.SetBit(100, true)
+	if rb.Len() != 13 {
+		t.Fatalf("SETBIT failed to grow the string, got length %d", rb.Len())
+	}
+}
+
+func TestRedisBitCount(t *testing.T) {
+	rb, _ := NewRedisBitmapFromBytes([]byte("foobar"))
+	if rb.BitCount() != 26 {
+		t.Fatalf("BITCOUNT failed, got %d", rb.BitCount())
+	}
+	tests := []struct {
+		start, end int64
+		unit       RangeUnit
+		expected   uint64
+	}{
+		{0, 0, ByteUnit, 4},
+		{1, 1, ByteUnit, 6},
+		{5, 30, BitUnit, 17},
+		{0, -1, ByteUnit, 26},
+		{-2, -1, ByteUnit, 7},
+		{-100, 100, ByteUnit, 26},
+		{3, 2, ByteUnit, 0},
+		{-1, -1, BitUnit, 0},
+		{-8, -1, BitUnit, 4},
+	}
+	for _, test := range tests {
+		if n := rb.BitCountRange(test.start, test.end, test.unit); n != test.expected {
+			t.Fatalf("BITCOUNT %d %d %d failed, expected %d, got %d", test.start, test.end,
+				test.unit, test.expected, n)
+		}
+	}
+	if NewRedisBitmap().BitCountRange(0, -1, ByteUnit) != 0 {
+		t.Fatal("BITCOUNT failed on a missing key")
+	}
+}
+
+func TestRedisBitPos(t *testing.T) {
+	rb, _ := NewRedisBitmapFromBytes([]byte{0xff, 0xf0, 0x00})
+	if pos := rb.BitPos(false); pos != 12 {
+		t.Fatalf("BITPOS 0 failed, got %d", pos)
+	}
+	rb.Set([]byte{0x00, 0xff, 0xf0})
+	if rb.BitPosFrom(true, 0) != 8 || rb.BitPosFrom(true, 2) != 16 ||
+		rb.BitPosRange(true, 2, -1, ByteUnit) != 16 || rb.BitPosRange(true, 7, 15, BitUnit) != 8 {
+		t.Fatal("BITPOS 1 failed")
+	}
+	rb.Set([]byte{0x00, 0x00, 0x00})
+	if rb.BitPos(true) != -1 || rb.BitPosRange(true, 7, -3, BitUnit) != -1 {
+		t.Fatal("BITPOS failed to return -1 for a missing bit")
+	}
+
+	// zero bits are looked for beyond the string unless an end is given
+	rb.Set([]byte{0xff, 0xff, 0xff})
+	if rb.BitPos(false) != 24 || rb.BitPosFrom(false, 1) != 24 ||
+		rb.BitPosRange(false, 0, -1, ByteUnit) != -1 {
+		t.Fatal("BITPOS 0 failed on a string of set bits")
+	}
+	if rb.BitPosFrom(false, 5) != -1 {
+		t.Fatal("BITPOS failed on an empty range")
+	}
+	empty := NewRedisBitmap()
+	if empty.BitPos(false) != 0 || empty.BitPos(true) != -1 {
+		t.Fatal("BITPOS failed on a missing key")
+	}
+}
+
+func TestRedisBitOp(t *testing.T) {
+	key1, _ := NewRedisBitmapFromBytes([]byte("foobar"))
+	key2, _ := NewRedisBitmapFromBytes([]byte("abcdef"))
+	dest := NewRedisBitmap()
+	if n, err := dest.BitOp(BitOpAnd, key1, key2); err != nil || n != 6 {
+		t.Fatalf("BITOP AND failed, got %d %v", n, err)
+	}
+	if string(dest.Bytes()) != "`bc`ab" {
+		t.Fatalf("BITOP AND failed, got %q", dest.Bytes())
+	}
+
+	// shorter strings are padded with zeros
+	short, _ := NewRedisBitmapFromBytes([]byte{0xf0})
+	long, _ := NewRedisBitmapFromBytes([]byte{0xff, 0x0f})
+	expected := map[BitOp]string{BitOpAnd: "\xf0\x00", BitOpOr: "\xff\x0f", BitOpXor: "\x0f\x0f"}
+	for op, value := range expected {
+		if n, _ := dest.BitOp(op, short, long); n != 2 || string(dest.Bytes()) != value {
+			t.Fatalf("BITOP %d failed, got %q", op, dest.Bytes())
+		}
+	}
+	if n, _ := dest.BitOp(BitOpNot, long); n != 2 || string(dest.Bytes()) != "\x00\xf0" {
+		t.Fatalf("BITOP NOT failed, got %q", dest.Bytes())
+	}
+	if _, err := dest.BitOp(BitOpNot, short, long); err != ErrSyntax {
+		t.Fatal("BITOP NOT failed to reject two sources")
+	}
+	if n, _ := dest.BitOp(BitOpOr, dest, short); n != 2 || string(dest.Bytes()) != "\xf0\xf0" {
+		t.Fatalf("BITOP failed with the destination as source, got %q", dest.Bytes())
+	}
+}
+
+func TestRedisBitfield(t *testing.T) {
+	rb := NewRedisBitmap()
+	res, err := rb.Bitfield("INCRBY", "i5", "100", "1", "GET", "u4", "0")
+	if err != nil || len(res) != 2 || *res[0] != 1 || *res[1] != 0 {
+		t.Fatalf("BITFIELD failed %v", err)
+	}
+
+	// the overflow example increments two counters, the second one saturating
+	rb = NewRedisBitmap()
+	for i, expected := range []int64{1, 2, 3, 0} {
+		res, err = rb.Bitfield("incrby", "u2", "100", "1", "OVERFLOW", "SAT", "incrby", "u2", "102", "1")
+		if err != nil || len(res) != 2 || *res[0] != expected || *res[1] != expected+int64(i/3*3) {
+			t.Fatalf("BITFIELD overflow failed at %d %v", i, err)
+		}
+	}
+	res, _ = rb.Bitfield("OVERFLOW", "FAIL", "incrby", "u2", "102", "1")
+	if len(res) != 1 || res[0] != nil {
+		t.Fatal("BITFIELD OVERFLOW FAIL failed")
+	}
+
+	// the string grows to hold the fields written even if the writes fail
+	rb = NewRedisBitmap()
+	res, _ = rb.Bitfield("OVERFLOW", "FAIL", "INCRBY", "u2", "100", "5")
+	if res[0] != nil || rb.Len() != 13 || rb.BitCountRange(-1, -1, ByteUnit) != 0 {
+		t.Fatalf("BITFIELD OVERFLOW FAIL failed to grow the string, got length %d", rb.Len())
+	}
+	res, _ = rb.Bitfield("GET", "u8", "200", "OVERFLOW", "FAIL", "SET", "i8", "150", "1000", "SET", "u4", "#3", "1")
+	if res[1] != nil || *res[2] != 0 || rb.Len() != 20 {
+		t.Fatalf("BITFIELD failed to grow the string first, got length %d", rb.Len())
+	}
+	u2, _ := ParseBitfieldType("u2")
+	if _, ok, _ := rb.BitfieldIncrBy(u2, 200, -1, OverflowFail); ok || rb.Len() != 26 {
+		t.Fatalf("BitfieldIncrBy failed to grow the string, got length %d", rb.Len())
+	}
+	if _, ok, _ := rb.BitfieldSet(u2, 210, 4, OverflowFail); ok || rb.Len() != 27 {
+		t.Fatalf("BitfieldSet failed to grow the string, got length %d", rb.Len())
+	}
+
+	rb = NewRedisBitmap()
+	res, _ = rb.Bitfield("SET", "i8", "#0", "100", "SET", "i8", "#1", "200", "GET", "i8", "#1")
+	if *res[0] != 0 || *res[1] != 0 || *res[2] != -56 || rb.Len() != 2 {
+		t.Fatal("BITFIELD SET failed")
+	}
+	i8, _ := ParseBitfieldType("i8")
+	u8, _ := ParseBitfieldType("U8")
+	tests := []struct {
+		t        BitfieldType
+		value    int64
+		incr     int64
+		overflow BitfieldOverflow
+		expected int64
+		ok       bool
+	}{
+		{i8, 127, 1, OverflowWrap, -128, true},
+		{i8, 127, 1, OverflowSat, 127, true},
+		{i8, 127, 1, OverflowFail, 0, false},
+		{i8, -100, -100, OverflowWrap, 56, true},
+		{i8, -100, -100, OverflowSat, -128, true},
+		{u8, 255, 1, OverflowWrap, 0, true},
+		{u8, 255, 10, OverflowSat, 255, true},
+		{u8, 10, -20, OverflowWrap, 246, true},
+		{u8, 10, -20, OverflowSat, 0, true},
+		{u8, 10, -20, OverflowFail, 0, false},
+		{BitfieldType{true, 64}, 1<<63 - 1, 1, OverflowWrap, -1 << 63, true},
+		{BitfieldType{true, 64}, -1 << 63, -1, OverflowSat, -1 << 63, true},
+		{BitfieldType{false, 63}, 1<<63 - 2, 2, OverflowSat, 1<<63 - 1, true},
+	}
+	for _, test := range tests {
+		rb = NewRedisBitmap()
+		rb.BitfieldSet(test.t, 3, test.value, OverflowWrap)
+		v, ok, err := rb.BitfieldIncrBy(test.t, 3, test.incr, test.overflow)
+		if err != nil || v != test.expected || ok != test.ok {
+			t.Fatalf("INCRBY %v %d %d failed, got %d %v %v", test.t, test.value, test.incr, v,
+				ok, err)
+		}
+		if v, _ = rb.BitfieldGet(test.t, 3); !ok && v != test.value {
+			t.Fatalf("INCRBY %v %d %d failed to keep the value", test.t, test.value, test.incr)
+		}
+	}
+
+	// the value of SET overflows too, a negative one being out of range of an unsigned type
+	rb = NewRedisBitmap()
+	if old, ok, _ := rb.BitfieldSet(u8, 0, -1, OverflowSat); !ok || old != 0 {
+		t.Fatal("BITFIELD SET failed")
+	}
+	if v, _ := rb.BitfieldGet(u8, 0); v != 255 {
+		t.Fatalf("BITFIELD SET failed to saturate, got %d", v)
+	}
+	if _, ok, _ := rb.BitfieldSet(i8, 0, 128, OverflowFail); ok {
+		t.Fatal("BITFIELD SET failed to fail")
+	}
+
+	for _, args := range [][]string{
+		{"GET", "u64", "0"}, {"GET", "i0", "0"}, {"GET", "x8", "0"}, {"GET", "u8"},
+		{"OVERFLOW", "NONE"}, {"SET", "u8", "0", "x"}, {"DEL", "u8", "0"},
+	} {
+		if _, err = rb.Bitfield(args...); err != ErrSyntax {
+			t.Fatalf("BITFIELD %v failed to return a syntax error", args)
+		}
+	}
+	for _, args := range [][]string{
+		{"GET", "u8", "-1"}, {"GET", "u8", "4294967289"}, {"GET", "u8", "#536870912"},
+	} {
+		if _, err = rb.Bitfield(args...); err != ErrRange {
+			t.Fatalf("BITFIELD %v failed to return a range error", args)
+		}
+	}
+
+	// nothing runs if an argument is invalid
+	rb.Bitfield("SET", "u8", "#0", "1", "GET", "u8", "x")
+	if v, _ := rb.BitfieldGet(u8, 0); v != 255 {
+		t.Fatal("BITFIELD failed to check the arguments first")
+	}
+	if v, _ := rb.BitfieldGet(u8, 1000); v != 0 || rb.Len() != 1 {
+		t.Fatal("BITFIELD GET failed beyond the end of the string")
+	}
+}
@@ -0,0 +1,107 @@
+package bitset
+
+import (
+	"sync"
+)
+
+// ReplayWindow protects against replayed messages the way IPsec does. It accepts every
+// sequence number at most once within a sliding window of the w numbers up to the highest
+// one accepted so far, and rejects the numbers already seen or older than the window. The
+// window is a circular Bitset where sequence number seq is bit seq%w. Accepting a number above
+// the window slides it forward, clearing the bits of the numbers skipped with ClearRange. It
+// is safe to use from multiple goroutines.
+type ReplayWindow struct {
+	mutex   sync.Mutex
+	bits    *Bitset
+	w       uint64
+	top     uint64
+	started bool
+}
+
+// NewReplayWindow returns a replay window of w sequence numbers. w is rounded up to a multiple
+// of 8 and is at least 8
+func NewReplayWindow(w uint32) *ReplayWindow {
+	if w == 0 {
+		w = 8
+	}
+	size := uint32((uint64(w) + 7) >> 3)
+	return &ReplayWindow{bits: NewBitset(size), w: uint64(size) << 3}
+}
+
+// Size returns the number of sequence numbers of the window
+func (rw *ReplayWindow) Size() uint64 {
+	return rw.w
+}
+
+// Top returns the highest sequence number accepted so far and false if none was accepted yet
+func (rw *ReplayWindow) Top() (uint64, bool) {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.top, rw.started
+}
+
+// Check returns true if seq would be accepted, that is if it is above the window or within
+// it and not seen yet. The window is not modified, so a message can be authenticated before
+// it is recorded with CheckAndUpdate
+func (rw *ReplayWindow) Check(seq uint64) bool {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	return rw.check(seq)
+}
+
+// CheckAndUpdate returns true and records seq if it is accepted, false if it is a duplicate or
+// older than the window. The check and the update are atomic, so of many goroutines receiving
+// the same sequence number only one gets true
+func (rw *ReplayWindow) CheckAndUpdate(seq uint64) bool {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	if !rw.check(seq) {
+		return false
+	}
+	if !rw.started || seq > rw.top {
+		rw.advance(seq)
+	}
+	rw.bits.SetBit(uint32(seq % rw.w))
+	return true
+}
+
+// Reset forgets all the sequence numbers seen
+func (rw *ReplayWindow) Reset() {
+	rw.mutex.Lock()
+	defer rw.mutex.Unlock()
+	rw.bits.ClearAll()
+	rw.top = 0
+	rw.started = false
+}
+
+// check does the work of Check, the caller must hold the lock
+func (rw *ReplayWindow) check(seq uint64) bool {
+	if !rw.started || seq > rw.top {
+		return true
+	}
+	if rw.top-seq >= rw.w {
+		return false
+	}
+	set, _ := rw.bits.IsSet(uint32(seq % rw.w))
+	return !set
+}
+
+// advance slides the window up to seq, clearing the bits of the numbers above the previous
+// top. The caller must hold the lock
+func (rw *ReplayWindow) advance(seq uint64) {
+	if !rw.started {
+		rw.started = true
+	} else if seq-rw.top >= rw.w {
+		rw.bits.ClearAll()
+	} else {
+		start := uint32((rw.top + 1) % rw.w)
+		end := uint32(seq % rw.w)
+		if start <= end {
+			rw.bits.ClearRange(start, end)
+		} else {
+			rw.bits.ClearRange(start, uint32(rw.w-1))
+			rw.bits.ClearRange(0, end)
+		}
+	}
+	rw.top = seq
+}
@@ -0,0 +1,74 @@
+package bitset
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplayWindow(t *testing.T) {
+	rw := NewReplayWindow(60)
+	if rw.Size() != 64 {
+		t.Fatalf("NewReplayWindow failed, got %d", rw.Size())
+	}
+	if _, ok := rw.Top(); ok {
+		t.Fatal("Top failed for new window")
+	}
+	if !rw.Check(100) || !rw.Check(100) {
+		t.Fatal("Check failed for new window")
+	}
+	if !rw.CheckAndUpdate(100) || rw.CheckAndUpdate(100) || rw.Check(100) {
+		t.Fatal("CheckAndUpdate failed to reject duplicate")
+	}
+	// numbers below the first one are accepted while within the window
+	if !rw.CheckAndUpdate(37) || rw.CheckAndUpdate(36) {
+		t.Fatal("CheckAndUpdate failed at the bottom of the window")
+	}
+	if !rw.CheckAndUpdate(130) || !rw.CheckAndUpdate(105) || rw.CheckAndUpdate(105) {
+		t.Fatal("CheckAndUpdate failed within the window")
+	}
+	if rw.Check(66) || !rw.Check(67) {
+		t.Fatal("Check failed, window did not slide")
+	}
+	// 100 and 130 share no bit, but 36+64 = 100 did and was cleared by the slide
+	if rw.Check(130) || rw.Check(100) {
+		t.Fatal("Check failed, seen numbers forgotten")
+	}
+	if top, ok := rw.Top(); !ok || top != 130 {
+		t.Fatalf("Top failed, got %d", top)
+	}
+	// wrapping slide
+	if !rw.CheckAndUpdate(170) || rw.Check(130) || !rw.Check(131) || !rw.Check(169) {
+		t.Fatal("CheckAndUpdate failed, wrapping slide")
+	}
+	// slide beyond the whole window
+	if !rw.CheckAndUpdate(1000) || rw.Check(170) || !rw.Check(999) || rw.Check(936) {
+		t.Fatal("CheckAndUpdate failed, long slide")
+	}
+	rw.Reset()
+	if !rw.Check(5) {
+		t.Fatal("Reset failed")
+	}
+}
+
+func TestReplayWindowConcurrent(t *testing.T) {
+	rw := NewReplayWindow(1024)
+	var accepted int64
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := uint64(1); seq <= 2000; seq++ {
+				if rw.CheckAndUpdate(seq) {
+					atomic.AddInt64(&accepted, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// every number is accepted once, unless a goroutine fell behind the window
+	if accepted > 2000 || accepted < 976 {
+		t.Fatalf("CheckAndUpdate failed, accepted %d", accepted)
+	}
+}
@@ -0,0 +1,159 @@
+package bitset
+
+import (
+	"sync"
+	"time"
+)
+
+// RotatingBloomFilter answers whether a key was added within a sliding window of time. It
+// holds a ring of generations, each a BloomFilter over its own Bitset. Keys are added to the
+// current generation and looked up in all of them. Every period the oldest generation is
+// cleared with ClearAll and becomes the current one, so a key expires between
+// (generations-1)*period and generations*period after it was last added. Rotation happens
+// lazily on the next call once a period elapsed, according to a clock which can be replaced
+// with SetClock for tests. It is safe to use from multiple goroutines.
+type RotatingBloomFilter struct {
+	mutex   sync.Mutex
+	gens    []*BloomFilter
+	current int
+	period  time.Duration
+	now     func() time.Time
+	rotated time.Time
+}
+
+// NewRotatingBloomFilter returns an empty rotating Bloom filter of generations generations
+// rotated every period, each of m bits probed k times per key. There are at least 2
+// generations and period is at least one nanosecond
+func NewRotatingBloomFilter(generations int, period time.Duration, m uint64,
+	k uint32) *RotatingBloomFilter {
+	if generations < 2 {
+		generations = 2
+	}
+	if period <= 0 {
+		period = 1
+	}
+	gens := make([]*BloomFilter, generations)
+	for i := range gens {
+		gens[i] = NewBloomFilter(m, k)
+	}
+	return &RotatingBloomFilter{gens: gens, period: period, now: time.Now, rotated: time.Now()}
+}
+
+// NewRotatingBloomFilterWithEstimates returns an empty rotating Bloom filter of generations
+// generations rotated every period, each sized to hold n keys with a false positive rate of p
+func NewRotatingBloomFilterWithEstimates(generations int, period time.Duration, n uint64,
+	p float64) *RotatingBloomFilter {
+	m, k := EstimateBloomParameters(n, p)
+	return NewRotatingBloomFilter(generations, period, m, k)
+}
+
+// SetClock replaces the clock of the filter, time.Now by default. The current generation is
+// considered started at the time now returns when SetClock is called
+func (rf *RotatingBloomFilter) SetClock(now func() time.Time) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.now = now
+	rf.rotated = now()
+}
+
+// Generations returns the number of generations of the filter
+func (rf *RotatingBloomFilter) Generations() int {
+	return len(rf.gens)
+}
+
+// Add adds key to the current generation of the filter
+func (rf *RotatingBloomFilter) Add(key []byte) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.advance()
+	rf.gens[rf.current].Add(key)
+}
+
+// AddString adds key to the current generation of the filter
+func (rf *RotatingBloomFilter) AddString(key string) {
+	rf.Add([]byte(key))
+}
+
+// Test returns true if key may have been added to any of the live generations, false if it
+// was surely not
+func (rf *RotatingBloomFilter) Test(key []byte) bool {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.advance()
+	return rf.test(key)
+}
+
+// TestString returns true if key may have been added to any of the live generations
+func (rf *RotatingBloomFilter) TestString(key string) bool {
+	return rf.Test([]byte(key))
+}
+
+// TestAndAdd adds key to the current generation and returns what Test would have returned
+// before
+func (rf *RotatingBloomFilter) TestAndAdd(key []byte) bool {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.advance()
+	present := rf.test(key)
+	rf.gens[rf.current].Add(key)
+	return present
+}
+
+// TestAndAddString adds key to the current generation and returns what TestString would have
+// returned before
+func (rf *RotatingBloomFilter) TestAndAddString(key string) bool {
+	return rf.TestAndAdd([]byte(key))
+}
+
+// Rotate clears the oldest generation and makes it the current one without waiting for the
+// period to elapse. The next rotation happens a period later
+func (rf *RotatingBloomFilter) Rotate() {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	rf.rotate()
+	rf.rotated = rf.now()
+}
+
+// ClearAll removes all the keys from all the generations
+func (rf *RotatingBloomFilter) ClearAll() {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+	for _, bf := range rf.gens {
+		bf.ClearAll()
+	}
+	rf.rotated = rf.now()
+}
+
+// test returns true if key may be in any generation, the caller must hold the lock
+func (rf *RotatingBloomFilter) test(key []byte) bool {
+	for _, bf := range rf.gens {
+		if bf.Test(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// advance rotates once per period elapsed since the last rotation, at most once per
+// generation. The caller must hold the lock
+func (rf *RotatingBloomFilter) advance() {
+	elapsed := rf.now().Sub(rf.rotated)
+	if elapsed < rf.period {
+		return
+	}
+	periods := elapsed / rf.period
+	rf.rotated = rf.rotated.Add(periods * rf.period)
+	if periods > time.Duration(len(rf.gens)) {
+		periods = time.Duration(len(rf.gens))
+	}
+	for ; periods > 0; periods-- {
+		rf.rotate()
+	}
+}
+
+// rotate clears the oldest generation and makes it the current one, the caller must hold the
+// lock
+func (rf *RotatingBloomFilter) rotate() {
+	rf.current = (rf.current + 1) % len(rf.gens)
+	rf.gens[rf.current].ClearAll()
+}
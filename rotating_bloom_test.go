@@ -0,0 +1,59 @@
+package bitset
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRotatingBloomFilter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rf := NewRotatingBloomFilterWithEstimates(3, time.Minute, 1000, 0.01)
+	rf.SetClock(func() time.Time { return now })
+	if rf.Generations() != 3 {
+		t.Fatalf("NewRotatingBloomFilter failed, got %d generations", rf.Generations())
+	}
+
+	rf.AddString("first")
+	now = now.Add(time.Minute)
+	if rf.TestAndAddString("second") {
+		t.Fatal("TestAndAdd failed for new key")
+	}
+	now = now.Add(90 * time.Second)
+	if !rf.TestString("first") || !rf.TestString("second") {
+		t.Fatal("Test failed, key expired too early")
+	}
+	// "first" was added 3 periods ago
+	now = now.Add(30 * time.Second)
+	if rf.TestString("first") {
+		t.Fatal("Test failed, key did not expire")
+	}
+	if !rf.TestString("second") {
+		t.Fatal("Test failed, key expired too early")
+	}
+	// re-adding a key extends its life
+	rf.AddString("second")
+	now = now.Add(2 * time.Minute)
+	if !rf.TestString("second") {
+		t.Fatal("Add failed to refresh key")
+	}
+	now = now.Add(time.Hour)
+	if rf.TestString("second") {
+		t.Fatal("Test failed, key did not expire after a long pause")
+	}
+
+	rf.AddString("third")
+	for i := 0; i < 3; i++ {
+		if !rf.TestString("third") {
+			t.Fatalf("Rotate failed, key expired after %d rotations", i)
+		}
+		rf.Rotate()
+	}
+	if rf.TestString("third") {
+		t.Fatal("Rotate failed, key did not expire")
+	}
+	rf.AddString("fourth")
+	rf.ClearAll()
+	if rf.TestString("fourth") {
+		t.Fatal("ClearAll failed")
+	}
+}
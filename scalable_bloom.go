@@ -0,0 +1,208 @@
+package bitset
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultScalableTightening is the ratio between the false positive rates of successive
+	// slices of a ScalableBloomFilter
+	DefaultScalableTightening = 0.8
+	// DefaultScalableGrowth is the ratio between the capacities of successive slices of a
+	// ScalableBloomFilter
+	DefaultScalableGrowth = 2
+	// DefaultScalableFillRatio is the fill ratio of the current slice of a ScalableBloomFilter
+	// beyond which a new slice is added. A slice holding its designed number of keys is half
+	// full
+	DefaultScalableFillRatio = 0.5
+)
+
+// ScalableBloomFilter is a Bloom filter which grows with the number of keys added, keeping
+// its false positive rate bounded without knowing the number of keys up front. It is made of
+// successive BloomFilter slices, and keys are added to the last one. Once the fill ratio of
+// the last slice crosses a threshold a new slice is added, larger than the previous one and
+// with a tighter false positive rate, so that the compounded rate of all the slices converges
+// below the rate the filter was created with. It is safe to use from multiple goroutines.
+type ScalableBloomFilter struct {
+	mutex      sync.RWMutex
+	slices     []*BloomFilter
+	n          uint64
+	p          float64
+	tightening float64
+	growth     float64
+	fillratio  float64
+	// added counts the keys added to the last slice since its fill ratio was last checked
+	added uint64
+}
+
+// NewScalableBloomFilter returns an empty scalable Bloom filter whose first slice is sized to
+// hold n keys and whose compounded false positive rate stays below p
+func NewScalableBloomFilter(n uint64, p float64) *ScalableBloomFilter {
+	return NewScalableBloomFilterWithParameters(n, p, DefaultScalableTightening,
+		DefaultScalableGrowth, DefaultScalableFillRatio)
+}
+
+// NewScalableBloomFilterWithParameters returns an empty scalable Bloom filter whose first
+// slice is sized to hold n keys and whose compounded false positive rate stays below p.
+// Successive slices have their false positive rate multiplied by tightening and their
+// capacity multiplied by growth, and a slice is added once the last one is filled to
+// fillratio. Parameters out of range are replaced by the defaults
+func NewScalableBloomFilterWithParameters(n uint64, p float64, tightening float64, growth float64,
+	fillratio float64) *ScalableBloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	if tightening <= 0 || tightening >= 1 {
+		tightening = DefaultScalableTightening
+	}
+	if growth < 1 {
+		growth = DefaultScalableGrowth
+	}
+	if fillratio <= 0 || fillratio >= 1 {
+		fillratio = DefaultScalableFillRatio
+	}
+	sf := &ScalableBloomFilter{n: n, p: p, tightening: tightening, growth: growth,
+		fillratio: fillratio}
+	sf.grow()
+	return sf
+}
+
+// Slices returns the number of slices of the filter
+func (sf *ScalableBloomFilter) Slices() int {
+	sf.mutex.RLock()
+	defer sf.mutex.RUnlock()
+	return len(sf.slices)
+}
+
+// Cap returns the total number of bits of the slices of the filter
+func (sf *ScalableBloomFilter) Cap() uint64 {
+	sf.mutex.RLock()
+	defer sf.mutex.RUnlock()
+	var ret uint64 = 0
+	for _, bf := range sf.slices {
+		ret += bf.Cap()
+	}
+	return ret
+}
+
+// Add adds key to the filter, unless it may already be in it
+func (sf *ScalableBloomFilter) Add(key []byte) {
+	sf.TestAndAdd(key)
+}
+
+// AddString adds key to the filter
+func (sf *ScalableBloomFilter) AddString(key string) {
+	sf.Add([]byte(key))
+}
+
+// Test returns true if key may have been added to the filter, false if it was surely not
+func (sf *ScalableBloomFilter) Test(key []byte) bool {
+	sf.mutex.RLock()
+	defer sf.mutex.RUnlock()
+	return sf.test(key)
+}
+
+// TestString returns true if key may have been added to the filter, false if it was surely
+// not
+func (sf *ScalableBloomFilter) TestString(key string) bool {
+	return sf.Test([]byte(key))
+}
+
+// TestAndAdd adds key to the filter and returns what Test would have returned before. A key
+// which may already be in the filter is not added again, so it doesn't fill the last slice
+func (sf *ScalableBloomFilter) TestAndAdd(key []byte) bool {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	if sf.test(key) {
+		return true
+	}
+	last := sf.slices[len(sf.slices)-1]
+	last.Add(key)
+	sf.added++
+	// counting the set bits scans the whole slice, so the fill ratio is checked only after a
+	// fraction of the keys the slice is designed for were added
+	if sf.added >= sf.checkInterval(last) {
+		sf.added = 0
+		if last.FillRatio() >= sf.fillratio {
+			sf.grow()
+		}
+	}
+	return false
+}
+
+// TestAndAddString adds key to the filter and returns what TestString would have returned
+// before
+func (sf *ScalableBloomFilter) TestAndAddString(key string) bool {
+	return sf.TestAndAdd([]byte(key))
+}
+
+// EstimatedCount returns an estimate of the number of distinct keys added, the sum of the
+// estimates of the slices
+func (sf *ScalableBloomFilter) EstimatedCount() uint64 {
+	sf.mutex.RLock()
+	defer sf.mutex.RUnlock()
+	var ret uint64 = 0
+	for _, bf := range sf.slices {
+		count := bf.EstimatedCount()
+		if count == math.MaxUint64 {
+			return count
+		}
+		ret += count
+	}
+	return ret
+}
+
+// FalsePositiveRate returns the current probability of Test returning true for a key that was
+// not added, compounded over all the slices
+func (sf *ScalableBloomFilter) FalsePositiveRate() float64 {
+	sf.mutex.RLock()
+	defer sf.mutex.RUnlock()
+	negative := 1.0
+	for _, bf := range sf.slices {
+		negative *= 1 - bf.FalsePositiveRate()
+	}
+	return 1 - negative
+}
+
+// ClearAll removes all the keys from the filter, shrinking it back to a single slice
+func (sf *ScalableBloomFilter) ClearAll() {
+	sf.mutex.Lock()
+	defer sf.mutex.Unlock()
+	sf.slices = sf.slices[:1]
+	sf.slices[0].ClearAll()
+	sf.added = 0
+}
+
+// test returns true if key may be in any of the slices, the caller must hold the lock
+func (sf *ScalableBloomFilter) test(key []byte) bool {
+	for _, bf := range sf.slices {
+		if bf.Test(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// grow appends a new slice. The i-th slice holds n*growth^i keys at the rate
+// p*(1-tightening)*tightening^i, so that the rates of all the slices sum up to at most p. The
+// caller must hold the write lock
+func (sf *ScalableBloomFilter) grow() {
+	i := float64(len(sf.slices))
+	n := float64(sf.n) * math.Pow(sf.growth, i)
+	if n > maxBloomBits {
+		n = maxBloomBits
+	}
+	p := sf.p * (1 - sf.tightening) * math.Pow(sf.tightening, i)
+	sf.slices = append(sf.slices, NewBloomFilterWithEstimates(uint64(n), p))
+	sf.added = 0
+}
+
+// checkInterval returns the number of keys added to bf between two checks of its fill ratio
+func (sf *ScalableBloomFilter) checkInterval(bf *BloomFilter) uint64 {
+	// a slice is half full with about m*ln2/k keys
+	return uint64(float64(bf.Cap())*math.Ln2/float64(bf.K()))/64 + 1
+}
@@ -0,0 +1,47 @@
+package bitset
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScalableBloomFilter(t *testing.T) {
+	sf := NewScalableBloomFilter(100, 0.01)
+	if sf.Slices() != 1 {
+		t.Fatalf("NewScalableBloomFilter failed, got %d slices", sf.Slices())
+	}
+	for i := 0; i < 5000; i++ {
+		sf.AddString(fmt.Sprintf("key-%d", i))
+	}
+	if sf.Slices() < 4 {
+		t.Fatalf("Add failed to grow, got %d slices", sf.Slices())
+	}
+	for i := 0; i < 5000; i++ {
+		if !sf.TestString(fmt.Sprintf("key-%d", i)) {
+			t.Fatalf("Test failed, false negative for key-%d", i)
+		}
+	}
+	falsepositives := 0
+	for i := 0; i < 10000; i++ {
+		if sf.TestString(fmt.Sprintf("other-%d", i)) {
+			falsepositives++
+		}
+	}
+	if falsepositives > 150 {
+		t.Fatalf("Test failed, %d false positives in 10000", falsepositives)
+	}
+	if rate := sf.FalsePositiveRate(); rate > 0.015 {
+		t.Fatalf("FalsePositiveRate failed, got %f", rate)
+	}
+	if count := sf.EstimatedCount(); count < 4500 || count > 5500 {
+		t.Fatalf("EstimatedCount failed, got %d", count)
+	}
+	if !sf.TestAndAddString("key-7") || sf.TestAndAddString("new") {
+		t.Fatal("TestAndAdd failed")
+	}
+
+	sf.ClearAll()
+	if sf.Slices() != 1 || sf.TestString("key-7") {
+		t.Fatal("ClearAll failed")
+	}
+}
@@ -0,0 +1,231 @@
+package bitset
+
+import (
+	"io"
+)
+
+// SnapshotPageSize is the granularity in bytes at which a Snapshot copies the pages of the
+// bitset that are written after the snapshot was taken
+const SnapshotPageSize = 4096
+
+// Snapshot is a read-only point-in-time view of a Bitset. It shares the underlying byte array
+// with the live bitset, and a page is copied only when the live bitset is about to write to it
+// for the first time after the snapshot was taken. Taking a snapshot is cheap and long-running
+// readers of a snapshot see a consistent image while writers keep going. Modifications made
+// through the slice returned by GetBytesUnsafe are not detected and leak into the snapshot.
+// A snapshot costs a page copy on writes until it is released, so call Release when done.
+type Snapshot struct {
+	parent *Bitset
+	size   uint32
+	base   []byte
+	pages  map[uint32][]byte
+}
+
+// Snapshot returns a point-in-time read-only view of the bitset
+func (bs *Bitset) Snapshot() *Snapshot {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	s := &Snapshot{parent: bs, size: bs.size, base: bs.buf, pages: make(map[uint32][]byte)}
+	bs.snapshots = append(bs.snapshots, s)
+	return s
+}
+
+// Release detaches the snapshot from the live bitset so that writes no longer copy pages for
+// it. The snapshot must not be used after it is released, it behaves as an empty bitset
+func (s *Snapshot) Release() {
+	bs := s.parent
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	for i, other := range bs.snapshots {
+		if other == s {
+			bs.snapshots = append(bs.snapshots[:i], bs.snapshots[i+1:]...)
+			break
+		}
+	}
+	s.size = 0
+	s.base = nil
+	s.pages = nil
+}
+
+// GetSize returns the size of the snapshot in bytes
+func (s *Snapshot) GetSize() uint32 {
+	s.parent.mutex.RLock()
+	defer s.parent.mutex.RUnlock()
+	return s.size
+}
+
+// IsSet returns true if the bit is set at position in the snapshot, false otherwise. error
+// retuned will be non-nil if the position exceeds the snapshot capacity, nil otherwise
+func (s *Snapshot) IsSet(position uint32) (bool, error) {
+	b, err := s.GetByte(position)
+	if err != nil {
+		return false, err
+	}
+	return b&ones[s.parent.bitpos(position)] != 0, nil
+}
+
+// GetByte returns byte that contains bit corresponding to the position in the snapshot.
+// Non-nil error is returned in case the position is out of range
+func (s *Snapshot) GetByte(position uint32) (byte, error) {
+	s.parent.mutex.RLock()
+	defer s.parent.mutex.RUnlock()
+	bytepos := position >> 3
+	if bytepos >= s.size {
+		return byte(0), ErrRange
+	}
+	if page, ok := s.pages[bytepos/SnapshotPageSize]; ok {
+		return page[bytepos%SnapshotPageSize], nil
+	}
+	return s.base[bytepos], nil
+}
+
+// ReadAt copies the bytes of the snapshot starting at offset off into p, implementing
+// io.ReaderAt. The lock of the live bitset is held only while copying one page, so reading
+// a large snapshot in small chunks doesn't stall the writers
+func (s *Snapshot) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		m, err := s.readPage(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteTo writes the bytes of the snapshot to w page by page, implementing io.WriterTo
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, SnapshotPageSize)
+	var written int64 = 0
+	for {
+		n, err := s.readPage(buf, written)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// GetBytes returns a copy of the bytes of the snapshot
+func (s *Snapshot) GetBytes() []byte {
+	ret := make([]byte, s.GetSize())
+	s.ReadAt(ret, 0)
+	return ret
+}
+
+// GetSetbitCount returns the number of set or 1 bits in the snapshot
+func (s *Snapshot) GetSetbitCount() uint64 {
+	buf := make([]byte, SnapshotPageSize)
+	var ret uint64 = 0
+	var off int64 = 0
+	for {
+		n, err := s.readPage(buf, off)
+		for _, b := range buf[:n] {
+			ret += uint64(setbits[b])
+		}
+		off += int64(n)
+		if err != nil {
+			return ret
+		}
+	}
+}
+
+// GetZerobitCount returns the number of 0 bits in the snapshot
+func (s *Snapshot) GetZerobitCount() uint64 {
+	return (uint64(s.GetSize()) << 3) - s.GetSetbitCount()
+}
+
+// readPage copies into p the bytes of the snapshot starting at off up to the end of the page
+// holding off. io.EOF is returned once the end of the snapshot is reached
+func (s *Snapshot) readPage(p []byte, off int64) (int, error) {
+	s.parent.mutex.RLock()
+	defer s.parent.mutex.RUnlock()
+	if off < 0 || off >= int64(s.size) {
+		return 0, io.EOF
+	}
+	i := uint32(off)
+	pageno := i / SnapshotPageSize
+	pageoff := i % SnapshotPageSize
+	end := (pageno + 1) * SnapshotPageSize
+	if end > s.size {
+		end = s.size
+	}
+	if uint32(len(p)) > end-i {
+		p = p[:end-i]
+	}
+	var n int
+	if page, ok := s.pages[pageno]; ok {
+		n = copy(p, page[pageoff:])
+	} else {
+		n = copy(p, s.base[i:end])
+	}
+	if i+uint32(n) == s.size {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// preserve copies the pages holding the bytes first <= byte <= last which are not yet copied,
+// the caller must hold the write lock of the live bitset
+func (s *Snapshot) preserve(first uint32, last uint32) {
+	if first >= s.size {
+		return
+	}
+	if last >= s.size {
+		last = s.size - 1
+	}
+	for pageno := first / SnapshotPageSize; pageno <= last/SnapshotPageSize; pageno++ {
+		if _, ok := s.pages[pageno]; ok {
+			continue
+		}
+		start := pageno * SnapshotPageSize
+		end := start + SnapshotPageSize
+		if end > s.size {
+			end = s.size
+		}
+		page := make([]byte, end-start)
+		copy(page, s.base[start:end])
+		s.pages[pageno] = page
+	}
+}
+
+// beforeWrite must be called before modifying the bytes first <= byte <= last of the
+// underlying byte array so that the snapshots sharing it keep their content. The caller must
+// hold the write lock
+func (bs *Bitset) beforeWrite(first uint32, last uint32) {
+	for _, s := range bs.snapshots {
+		s.preserve(first, last)
+	}
+}
+
+// beforeWriteAll is beforeWrite for all the bytes of the underlying byte array
+func (bs *Bitset) beforeWriteAll() {
+	if bs.size != 0 {
+		bs.beforeWrite(0, bs.size-1)
+	}
+}
+
+// detachSnapshots must be called before the underlying byte array is replaced. The old array
+// is then owned by the snapshots and never written again, so they stop tracking the writes.
+// An array mapped from a file is about to be unmapped, so the snapshots get a copy of it.
+// The caller must hold the write lock
+func (bs *Bitset) detachSnapshots() {
+	if bs.mapping != nil {
+		for _, s := range bs.snapshots {
+			base := make([]byte, len(s.base))
+			copy(base, s.base)
+			s.base = base
+		}
+	}
+	bs.snapshots = nil
+}
@@ -0,0 +1,77 @@
+package bitset
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	bs := NewBitset(3 * SnapshotPageSize)
+	bs.SetRange(0, 99)
+	snap := bs.Snapshot()
+	expected := bs.GetBytes()
+
+	bs.ClearRange(0, 99)
+	bs.SetBit(SnapshotPageSize*8 + 1)
+	bs.SetAll()
+	if ret, err := snap.IsSet(50); err != nil || !ret {
+		t.Fatal("Snapshot failed, bit 50 changed after the snapshot")
+	}
+	if ret, err := snap.IsSet(SnapshotPageSize*8 + 1); err != nil || ret {
+		t.Fatal("Snapshot failed, bit changed after the snapshot")
+	}
+	if snap.GetSetbitCount() != 100 {
+		t.Fatalf("Snapshot failed, expected 100 set bits, got %d", snap.GetSetbitCount())
+	}
+	if !bytes.Equal(snap.GetBytes(), expected) {
+		t.Fatal("Snapshot failed, contents changed after the snapshot")
+	}
+	var buf bytes.Buffer
+	n, err := snap.WriteTo(&buf)
+	if err != nil || n != 3*SnapshotPageSize || !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("WriteTo failed, wrote %d, %v", n, err)
+	}
+	chunk := make([]byte, 10)
+	if n, err := snap.ReadAt(chunk, SnapshotPageSize-5); err != nil || n != 10 {
+		t.Fatalf("ReadAt failed, read %d, %v", n, err)
+	}
+	if !bytes.Equal(chunk, expected[SnapshotPageSize-5:SnapshotPageSize+5]) {
+		t.Fatal("ReadAt failed, unexpected contents")
+	}
+	if bs.GetSetbitCount() != 3*SnapshotPageSize*8 {
+		t.Fatal("Snapshot failed, live bitset modified")
+	}
+
+	// the old buffer is handed over to the snapshot on resize
+	bs.Resize(10)
+	bs.ClearAll()
+	if !bytes.Equal(snap.GetBytes(), expected) {
+		t.Fatal("Snapshot failed, contents changed after Resize")
+	}
+	snap.Release()
+	if snap.GetSize() != 0 || len(bs.snapshots) != 0 {
+		t.Fatal("Release failed")
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	bs := NewBitset(4 * SnapshotPageSize)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint32(0); i < 4*SnapshotPageSize*8; i += 7 {
+			bs.SetBit(i)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		snap := bs.Snapshot()
+		count := snap.GetSetbitCount()
+		if NewBitsetFromArray(snap.GetBytes()).GetSetbitCount() != count {
+			t.Fatal("Snapshot failed, contents changed while reading")
+		}
+		snap.Release()
+	}
+	wg.Wait()
+}
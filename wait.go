@@ -0,0 +1,86 @@
+package bitset
+
+import (
+	"context"
+	"sync"
+)
+
+// WaitForBit blocks until the bit at position is set or ctx is done. It returns ctx.Err() if
+// the context is done first and ErrRange if position is out of range
+func (bs *Bitset) WaitForBit(ctx context.Context, position uint32) error {
+	return bs.waitUntil(ctx, func() (bool, error) {
+		bytepos, bitpos, err := bs.getBitBytePosition(position)
+		if err != nil {
+			return false, err
+		}
+		return bs.buf[bytepos]&ones[bitpos] != 0, nil
+	})
+}
+
+// WaitForBitClear blocks until the bit at position is zero or ctx is done. It returns ctx.Err()
+// if the context is done first and ErrRange if position is out of range
+func (bs *Bitset) WaitForBitClear(ctx context.Context, position uint32) error {
+	return bs.waitUntil(ctx, func() (bool, error) {
+		bytepos, bitpos, err := bs.getBitBytePosition(position)
+		if err != nil {
+			return false, err
+		}
+		return bs.buf[bytepos]&ones[bitpos] == 0, nil
+	})
+}
+
+// WaitForAllSet blocks until all the bits in positions start <= position <= end are set or ctx
+// is done. It returns ctx.Err() if the context is done first and ErrRange if any of the
+// positions is out of range
+func (bs *Bitset) WaitForAllSet(ctx context.Context, start uint32, end uint32) error {
+	if start > end {
+		start, end = end, start
+	}
+	return bs.waitUntil(ctx, func() (bool, error) {
+		if end>>3 >= bs.size {
+			return false, ErrRange
+		}
+		for i := start >> 3; i <= end>>3; i++ {
+			mask := bs.msb(rangeMask(i, start, end))
+			if bs.buf[i]&mask != mask {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// WaitForCount blocks until at least count bits in the bitset are set or ctx is done. It
+// returns ctx.Err() if the context is done first
+func (bs *Bitset) WaitForCount(ctx context.Context, count uint64) error {
+	return bs.waitUntil(ctx, func() (bool, error) {
+		return bs.setbitc() >= count, nil
+	})
+}
+
+// waitUntil blocks until done returns true or a non-nil error, or ctx is done. done is called
+// with the write lock held, initially and after every modification of the bitset
+func (bs *Bitset) waitUntil(ctx context.Context, done func() (bool, error)) error {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	if bs.cond == nil {
+		bs.cond = sync.NewCond(bs.mutex)
+	}
+	cond := bs.cond
+	stop := context.AfterFunc(ctx, func() {
+		bs.mutex.Lock()
+		defer bs.mutex.Unlock()
+		cond.Broadcast()
+	})
+	defer stop()
+	for {
+		ok, err := done()
+		if ok || err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		cond.Wait()
+	}
+}
@@ -0,0 +1,77 @@
+package bitset
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForBit(t *testing.T) {
+	bs := NewBitset(2)
+	done := make(chan error, 1)
+	go func() {
+		done <- bs.WaitForBit(context.Background(), 9)
+	}()
+	bs.SetBit(3)
+	select {
+	case <-done:
+		t.Fatal("WaitForBit returned before the bit was set")
+	case <-time.After(20 * time.Millisecond):
+	}
+	bs.SetBit(9)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForBit failed %v", err)
+	}
+
+	go func() {
+		done <- bs.WaitForBitClear(context.Background(), 9)
+	}()
+	bs.ResetBit(9)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForBitClear failed %v", err)
+	}
+	if err := bs.WaitForBit(context.Background(), 16); err != ErrRange {
+		t.Fatal("WaitForBit failed to detect invalid position")
+	}
+}
+
+func TestWaitForAllSet(t *testing.T) {
+	bs := NewBitset(2)
+	done := make(chan error, 1)
+	go func() {
+		done <- bs.WaitForAllSet(context.Background(), 0, 7)
+	}()
+	for i := uint32(0); i < 8; i++ {
+		go bs.SetBit(i)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForAllSet failed %v", err)
+	}
+
+	go func() {
+		done <- bs.WaitForCount(context.Background(), 12)
+	}()
+	bs.SetRange(8, 10)
+	bs.Flip(15)
+	if err := <-done; err != nil {
+		t.Fatalf("WaitForCount failed %v", err)
+	}
+}
+
+func TestWaitCancel(t *testing.T) {
+	bs := NewBitset(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bs.WaitForCount(ctx, 1)
+	}()
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("WaitForCount failed, expected context.Canceled, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := bs.WaitForAllSet(ctx, 0, 15); err != context.DeadlineExceeded {
+		t.Fatalf("WaitForAllSet failed, expected context.DeadlineExceeded, got %v", err)
+	}
+}